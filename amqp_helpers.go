@@ -1,6 +1,7 @@
 package rbot
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -31,6 +32,53 @@ func CreateConsumeChannel(ch *amqp.Channel, name string) (<-chan amqp.Delivery,
 	)
 }
 
+func DeclareUpdatesExchange(ch *amqp.Channel, name string, kind string) error {
+	return ch.ExchangeDeclare(
+		name,  // name
+		kind,  // type
+		false, // durable
+		false, // auto-deleted
+		false, // internal
+		false, // no-wait
+		nil,   // arguments
+	)
+}
+
+// CreateUpdatesQueue declares a queue bound to an updates exchange. It is
+// deleted once its last consumer is cancelled, e.g. when ch is closed, so it
+// stops collecting updates nobody consumes.
+func CreateUpdatesQueue(ch *amqp.Channel, exchange string, kind string, key string) (amqp.Queue, error) {
+	err := DeclareUpdatesExchange(ch, exchange, kind)
+	if err != nil {
+		return amqp.Queue{}, NewErrorRemoteBot(FailedDeclareExchange, err)
+	}
+
+	q, err := ch.QueueDeclare(
+		"",    // name
+		false, // durable
+		true,  // delete when usused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return amqp.Queue{}, NewErrorRemoteBot(FailedDeclareQueue, err)
+	}
+
+	err = ch.QueueBind(
+		q.Name,   // queue name
+		key,      // routing key
+		exchange, // exchange
+		false,    // no-wait
+		nil,      // arguments
+	)
+	if err != nil {
+		return amqp.Queue{}, NewErrorRemoteBot(FailedBindQueue, err)
+	}
+
+	return q, nil
+}
+
 func CreateRpcBase(connection *amqp.Connection) (*amqp.Channel, amqp.Queue, <-chan amqp.Delivery, error) {
 	ch, err := connection.Channel()
 	if err != nil {
@@ -52,12 +100,38 @@ func CreateRpcBase(connection *amqp.Connection) (*amqp.Channel, amqp.Queue, <-ch
 	return ch, q, msgs, nil
 }
 
+// CreateDirectReplyToRpcBase is like CreateRpcBase but consumes from the
+// amq.rabbitmq.reply-to pseudo-queue, so no queue is declared. Requests must be
+// published on the returned channel for their replies to reach it.
+func CreateDirectReplyToRpcBase(connection *amqp.Connection) (*amqp.Channel, amqp.Queue, <-chan amqp.Delivery, error) {
+	ch, err := connection.Channel()
+	if err != nil {
+		return nil, amqp.Queue{}, make(chan amqp.Delivery), NewErrorRemoteBot(FailedOpenChannel, err)
+	}
+
+	q := amqp.Queue{Name: DirectReplyTo}
+
+	msgs, err := CreateConsumeChannel(ch, q.Name)
+	if err != nil {
+		ch.Close()
+		return nil, amqp.Queue{}, make(chan amqp.Delivery), NewErrorRemoteBot(FailedMessageConsume, err)
+	}
+
+	return ch, q, msgs, nil
+}
+
 func Publish(ch *amqp.Channel, requestMessage *RequestMessage, name string, request []byte) error {
+	return PublishTo(ch, "", RoutingKey, requestMessage, name, request)
+}
+
+// PublishTo is like Publish but sends the request to exchange with key as
+// routing key, for servers not using the default names.
+func PublishTo(ch *amqp.Channel, exchange string, key string, requestMessage *RequestMessage, name string, request []byte) error {
 	return ch.Publish(
-		"",         // exchange
-		RoutingKey, // routing key
-		false,      // mandatory
-		false,      // immediate
+		exchange, // exchange
+		key,      // routing key
+		false,    // mandatory
+		false,    // immediate
 		amqp.Publishing{
 			ContentType:   "text/plain",
 			CorrelationId: requestMessage.CorrelationId,
@@ -85,6 +159,26 @@ func PublishWithTimeout(ch *amqp.Channel, requestMessage *RequestMessage, name s
 	return nil
 }
 
+// publishWithContext runs publish, giving up when ctx is done.
+func publishWithContext(ctx context.Context, publish func() error) error {
+	errChan := make(chan error, 1)
+
+	go func() {
+		errChan <- publish()
+	}()
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			return NewErrorRemoteBot(FailedMessagePublish, err)
+		}
+	case <-ctx.Done():
+		return NewErrorRemoteBot(FailedMessagePublish, ctx.Err())
+	}
+
+	return nil
+}
+
 func ConsumeWithTimeout(correlationId string, msgs <-chan amqp.Delivery, ticker *time.Ticker) (*ResponseMessage, error) {
 	var response ResponseMessage
 	errChan := make(chan error, 1)
@@ -92,7 +186,8 @@ func ConsumeWithTimeout(correlationId string, msgs <-chan amqp.Delivery, ticker
 	go func() {
 		for d := range msgs {
 			if correlationId == d.CorrelationId {
-				err := json.Unmarshal(d.Body, &response)
+				var err error
+				response, err = decodeResponseDelivery(&d)
 				errChan <- err
 				break
 			}
@@ -111,6 +206,30 @@ func ConsumeWithTimeout(correlationId string, msgs <-chan amqp.Delivery, ticker
 	return &response, nil
 }
 
+func ConsumeWithContext(ctx context.Context, correlationId string, msgs <-chan amqp.Delivery) (*ResponseMessage, error) {
+	for {
+		select {
+		case d, ok := <-msgs:
+			if !ok {
+				return nil, NewErrorRemoteBot(FailedConnectionLost, nil)
+			}
+
+			if correlationId != d.CorrelationId {
+				continue
+			}
+
+			response, err := decodeResponseDelivery(&d)
+			if err != nil {
+				return nil, NewErrorRemoteBot(FailedConvertBodyResponse, err)
+			}
+
+			return &response, nil
+		case <-ctx.Done():
+			return nil, NewErrorRemoteBot(FailedMessageConsume, ctx.Err())
+		}
+	}
+}
+
 func RpcWithTimeout(ch *amqp.Channel, q amqp.Queue, msgs <-chan amqp.Delivery, requestMessage *RequestMessage, ticker *time.Ticker) (*ResponseMessage, error) {
 	request, err := json.Marshal(*requestMessage)
 	if err != nil {
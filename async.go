@@ -0,0 +1,133 @@
+package rbot
+
+import (
+	"context"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Pending is a call made asynchronously. Its result is available once Done
+// is closed.
+type Pending struct {
+	done     chan struct{}
+	response *ResponseMessage
+	err      error
+}
+
+func newPending() *Pending {
+	return &Pending{
+		done: make(chan struct{}),
+	}
+}
+
+// start runs call in the background, keeping its error, and cancels its
+// context when done.
+func (p *Pending) start(cancel context.CancelFunc, call func() error) {
+	go func() {
+		defer cancel()
+
+		p.err = call()
+		close(p.done)
+	}()
+}
+
+// goPending makes the call of requestMessage in the background and cancels
+// ctx when done.
+func (rbot *RemoteBotAPI) goPending(ctx context.Context, cancel context.CancelFunc, requestMessage *RequestMessage) *Pending {
+	p := newPending()
+
+	p.start(cancel, func() error {
+		var err error
+		p.response, err = rbot.rpcWithContext(ctx, requestMessage)
+		if err != nil {
+			return err
+		}
+
+		return p.response.R2.ToError()
+	})
+
+	return p
+}
+
+// Async runs call in the background and returns without waiting for it, so
+// any of the ...Context methods can be made asynchronously. call keeps the
+// result itself, it can be read once Wait returns:
+//
+//	var chat tgbotapi.Chat
+//	p := rbot.Async(ctx, func(ctx context.Context) (err error) {
+//		chat, err = rbot.GetChatContext(ctx, config)
+//		return err
+//	})
+//
+// Response and Message return no result for such calls.
+func (rbot *RemoteBotAPI) Async(ctx context.Context, call func(ctx context.Context) error) *Pending {
+	ctx, cancel := context.WithCancel(ctx)
+
+	p := newPending()
+	p.start(cancel, func() error {
+		return call(ctx)
+	})
+
+	return p
+}
+
+func (p *Pending) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait waits for the call to complete and returns its error.
+func (p *Pending) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Response waits for the call to complete and returns the raw response.
+func (p *Pending) Response() (*ResponseMessage, error) {
+	<-p.done
+	return p.response, p.err
+}
+
+// Message waits for a Send call to complete and returns its result.
+func (p *Pending) Message() (tgbotapi.Message, error) {
+	<-p.done
+	if p.response == nil {
+		return tgbotapi.Message{}, p.err
+	}
+
+	return p.response.R6, p.err
+}
+
+// SendAsync is like Send but returns without waiting for the reply.
+func (rbot *RemoteBotAPI) SendAsync(c tgbotapi.Chattable) *Pending {
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+
+	return rbot.goPending(ctx, cancel, newSendRequest(c))
+}
+
+func (rbot *RemoteBotAPI) SendAsyncContext(ctx context.Context, c tgbotapi.Chattable) *Pending {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return rbot.goPending(ctx, cancel, newSendRequest(c))
+}
+
+// SendNoReply publishes a Send call and returns once it is published, the
+// server sends no reply and the result is not known. Like the other calls, the
+// server skips it if not executed within Timeout.
+func (rbot *RemoteBotAPI) SendNoReply(c tgbotapi.Chattable) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
+
+	return rbot.SendNoReplyContext(ctx, c)
+}
+
+func (rbot *RemoteBotAPI) SendNoReplyContext(ctx context.Context, c tgbotapi.Chattable) error {
+	return rbot.notifyWithContext(ctx, newSendRequest(c))
+}
+
+func newSendRequest(c tgbotapi.Chattable) *RequestMessage {
+	return &RequestMessage{
+		Operation:     OperationSend,
+		CorrelationId: randomString(RandomStringLength),
+		C:             NewConcreteChattable(c),
+	}
+}
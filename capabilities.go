@@ -0,0 +1,186 @@
+package rbot
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Optional features of a server, reported by GetCapabilities.
+const (
+	FeatureUpdates        = "updates"
+	FeatureExpiration     = "expiration"
+	FeatureNoReply        = "no-reply"
+	FeatureRateLimit      = "rate-limit"
+	FeatureFloodWaitRetry = "flood-wait-retry"
+	FeatureIdempotency    = "idempotency"
+	FeatureDeadLetters    = "dead-letters"
+	FeaturePriorities     = "priorities"
+)
+
+// operations are the operations a server executes.
+var operations = []string{
+	OperationMakeRequest,
+	OperationUploadFile,
+	OperationGetFileDirectURL,
+	OperationGetMe,
+	OperationIsMessageToMe,
+	OperationSend,
+	OperationGetUserProfilePhotos,
+	OperationGetFile,
+	OperationGetUpdates,
+	OperationRemoveWebhook,
+	OperationSetWebhook,
+	OperationGetWebhookInfo,
+	OperationGetUpdatesChan,
+	OperationListenForWebhook,
+	OperationAnswerInlineQuery,
+	OperationAnswerCallbackQuery,
+	OperationKickChatMember,
+	OperationLeaveChat,
+	OperationGetChat,
+	OperationGetChatAdministrators,
+	OperationGetChatMembersCount,
+	OperationGetChatMember,
+	OperationUnbanChatMember,
+	OperationRestrictChatMember,
+	OperationPromoteChatMember,
+	OperationGetGameHighScores,
+	OperationAnswerShippingQuery,
+	OperationAnswerPreCheckoutQuery,
+	OperationDeleteMessage,
+	OperationGetInviteLink,
+	OperationPinChatMessage,
+	OperationUnpinChatMessage,
+	OperationSetChatTitle,
+	OperationSetChatDescription,
+	OperationSetChatPhoto,
+	OperationDeleteChatPhoto,
+	OperationGetCapabilities,
+}
+
+// Capabilities describes what a server supports: the latest protocol version
+// it speaks, the operations and Chattable types it executes and its enabled
+// features.
+type Capabilities struct {
+	ProtocolVersion int
+	Operations      []string
+	Chattables      []string
+	Features        []string
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Capabilities) HasOperation(operation string) bool {
+	return contains(c.Operations, operation)
+}
+
+func (c *Capabilities) HasChattable(chattable tgbotapi.Chattable) bool {
+	return contains(c.Chattables, reflect.TypeOf(chattable).String())
+}
+
+func (c *Capabilities) HasFeature(feature string) bool {
+	return contains(c.Features, feature)
+}
+
+// chattables returns the Chattable types ConcreteChattable can carry.
+func chattables() []string {
+	var types []string
+
+	t := reflect.TypeOf(ConcreteChattable{})
+	for i := 0; i < t.NumField(); i++ {
+		if strings.HasPrefix(t.Field(i).Name, "Value") {
+			types = append(types, t.Field(i).Type.String())
+		}
+	}
+
+	return types
+}
+
+// capabilities returns the capabilities of s as configured.
+func (s *Server) capabilities() Capabilities {
+	features := []string{FeatureUpdates, FeatureExpiration, FeatureNoReply}
+
+	var none Rate
+	if s.GlobalRate != none || s.ChatRate != none || s.GroupRate != none {
+		features = append(features, FeatureRateLimit)
+	}
+
+	if s.MaxFloodWait > 0 {
+		features = append(features, FeatureFloodWaitRetry)
+	}
+
+	if s.IdempotencyStore != nil {
+		features = append(features, FeatureIdempotency)
+	}
+
+	if s.DeadLetterExchange != "" {
+		features = append(features, FeatureDeadLetters)
+	}
+
+	if s.MaxPriority > 0 {
+		features = append(features, FeaturePriorities)
+	}
+
+	return Capabilities{
+		ProtocolVersion: ProtocolVersion,
+		Operations:      operations,
+		Chattables:      chattables(),
+		Features:        features,
+	}
+}
+
+func (rbot *RemoteBotAPI) GetCapabilities() (Capabilities, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
+
+	return rbot.GetCapabilitiesContext(ctx)
+}
+
+func (rbot *RemoteBotAPI) GetCapabilitiesContext(ctx context.Context) (Capabilities, error) {
+	var result Capabilities
+
+	requestMessage := RequestMessage{
+		Operation:     OperationGetCapabilities,
+		CorrelationId: randomString(RandomStringLength),
+	}
+
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
+	if remoteBotErr != nil {
+		return result, remoteBotErr
+	}
+
+	return response.R17, response.R2.ToError()
+}
+
+// checkCompatibility fails unless the server speaks the protocol version of
+// the client and has the features it requires.
+func (rbot *RemoteBotAPI) checkCompatibility(features []string) error {
+	capabilities, err := rbot.GetCapabilities()
+	if err != nil {
+		return NewErrorRemoteBot(FailedCompatibility, err)
+	}
+
+	if capabilities.ProtocolVersion < rbot.options.protocolVersion {
+		return NewErrorRemoteBot(FailedCompatibility, fmt.Errorf("server speaks protocol version %d", capabilities.ProtocolVersion))
+	}
+
+	for _, feature := range features {
+		if !capabilities.HasFeature(feature) {
+			return NewErrorRemoteBot(FailedCompatibility, fmt.Errorf("server lacks feature %q", feature))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package rbot
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	ContentTypeJSON = "application/json"
+	ContentTypeGob  = "application/x-gob"
+)
+
+// Codec encodes the messages exchanged through the broker. The content type
+// of a message selects the codec to decode it with.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec is much more compact than JSONCodec for big messages, like
+	// batches of updates. It needs a peer speaking ProtocolVersion,
+	// RemoteBotDial rejects it with LegacyProtocolVersion.
+	GobCodec Codec = gobCodec{}
+)
+
+// CodecFor returns the codec of contentType. Messages without a content type,
+// or sent as text/plain by older peers, are JSON.
+func CodecFor(contentType string) (Codec, error) {
+	switch contentType {
+	case "", "text/plain", ContentTypeJSON:
+		return JSONCodec, nil
+	case ContentTypeGob:
+		return GobCodec, nil
+	}
+
+	return nil, fmt.Errorf("unsupported content type %q", contentType)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return ContentTypeGob
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(v)
+
+	return b.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// The types tgbotapi configs hold in interface{} fields must be registered
+// for gob to encode them.
+func init() {
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+
+	gob.Register(tgbotapi.ReplyKeyboardMarkup{})
+	gob.Register(tgbotapi.ReplyKeyboardHide{})
+	gob.Register(tgbotapi.ReplyKeyboardRemove{})
+	gob.Register(tgbotapi.InlineKeyboardMarkup{})
+	gob.Register(tgbotapi.ForceReply{})
+	gob.Register(tgbotapi.FileBytes{})
+
+	gob.Register(tgbotapi.InputMediaPhoto{})
+	gob.Register(tgbotapi.InputMediaVideo{})
+
+	gob.Register(tgbotapi.InlineQueryResultArticle{})
+	gob.Register(tgbotapi.InlineQueryResultPhoto{})
+	gob.Register(tgbotapi.InlineQueryResultGIF{})
+	gob.Register(tgbotapi.InlineQueryResultMPEG4GIF{})
+	gob.Register(tgbotapi.InlineQueryResultVideo{})
+	gob.Register(tgbotapi.InlineQueryResultAudio{})
+	gob.Register(tgbotapi.InlineQueryResultVoice{})
+	gob.Register(tgbotapi.InlineQueryResultDocument{})
+	gob.Register(tgbotapi.InlineQueryResultLocation{})
+	gob.Register(tgbotapi.InlineQueryResultGame{})
+
+	gob.Register(tgbotapi.InputTextMessageContent{})
+	gob.Register(tgbotapi.InputLocationMessageContent{})
+	gob.Register(tgbotapi.InputVenueMessageContent{})
+	gob.Register(tgbotapi.InputContactMessageContent{})
+}
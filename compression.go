@@ -0,0 +1,92 @@
+package rbot
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+
+	DefaultCompressionThreshold = 4096
+
+	// HeaderAcceptEncoding lists the content encodings a client decodes in
+	// the replies to its requests.
+	HeaderAcceptEncoding = "x-rbot-accept-encoding"
+)
+
+// compress gzips body if it is longer than threshold, returning the body to
+// send and its content encoding. A threshold of zero disables compression.
+func compress(body []byte, threshold int) ([]byte, string) {
+	if threshold <= 0 || len(body) <= threshold {
+		return body, ""
+	}
+
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	_, err := w.Write(body)
+	if err == nil {
+		err = w.Close()
+	}
+
+	// Sending the body as it is stays correct.
+	if err != nil || b.Len() >= len(body) {
+		return body, ""
+	}
+
+	return b.Bytes(), EncodingGzip
+}
+
+// decompress returns the decoded body of a message with content encoding.
+func decompress(body []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return body, nil
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return ioutil.ReadAll(r)
+	case EncodingDeflate:
+		r := flate.NewReader(bytes.NewReader(body))
+		defer r.Close()
+
+		return ioutil.ReadAll(r)
+	}
+
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// acceptsGzip reports whether the client that published d decodes gzipped
+// replies.
+func acceptsGzip(d *amqp.Delivery) bool {
+	accept, _ := d.Headers[HeaderAcceptEncoding].(string)
+	return strings.Contains(accept, EncodingGzip)
+}
+
+// decodeResponseDelivery decodes the response carried by d according to its
+// content encoding and type.
+func decodeResponseDelivery(d *amqp.Delivery) (ResponseMessage, error) {
+	body, err := decompress(d.Body, d.ContentEncoding)
+	if err != nil {
+		return ResponseMessage{}, err
+	}
+
+	codec, err := CodecFor(d.ContentType)
+	if err != nil {
+		return ResponseMessage{}, err
+	}
+
+	response, _, err := DecodeResponse(codec, body)
+	return response, err
+}
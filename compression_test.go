@@ -0,0 +1,93 @@
+package rbot
+
+import (
+	"bytes"
+	"compress/flate"
+	"strings"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestCompress(t *testing.T) {
+	long := []byte(strings.Repeat("compressible ", 100))
+	short := []byte("short body")
+
+	tests := []struct {
+		name      string
+		body      []byte
+		threshold int
+		encoding  string
+	}{
+		{"disabled", long, 0, ""},
+		{"below threshold", long, len(long), ""},
+		{"above threshold", long, 16, EncodingGzip},
+		{"not smaller", short, 1, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, encoding := compress(test.body, test.threshold)
+			if encoding != test.encoding {
+				t.Fatalf("compress() encoding = %q, want %q", encoding, test.encoding)
+			}
+
+			if encoding == "" && !bytes.Equal(body, test.body) {
+				t.Error("compress() changed a body it did not encode")
+			}
+
+			got, err := decompress(body, encoding)
+			if err != nil {
+				t.Fatalf("decompress() error = %v", err)
+			}
+
+			if !bytes.Equal(got, test.body) {
+				t.Errorf("decompress() = %q, want %q", got, test.body)
+			}
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	body := []byte("body")
+
+	var deflated bytes.Buffer
+	w, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	w.Write(body)
+	w.Close()
+
+	got, err := decompress(deflated.Bytes(), EncodingDeflate)
+	if err != nil || !bytes.Equal(got, body) {
+		t.Errorf("decompress(deflate) = %q, %v, want %q", got, err, body)
+	}
+
+	if _, err := decompress(body, EncodingGzip); err == nil {
+		t.Error("decompress() of an invalid gzip body error = nil, want an error")
+	}
+
+	if _, err := decompress(body, "br"); err == nil {
+		t.Error("decompress() of an unsupported encoding error = nil, want an error")
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    bool
+	}{
+		{"no headers", nil, false},
+		{"gzip", amqp.Table{HeaderAcceptEncoding: EncodingGzip}, true},
+		{"list", amqp.Table{HeaderAcceptEncoding: "deflate, gzip"}, true},
+		{"other encoding", amqp.Table{HeaderAcceptEncoding: EncodingDeflate}, false},
+		{"not a string", amqp.Table{HeaderAcceptEncoding: int32(1)}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := acceptsGzip(&amqp.Delivery{Headers: test.headers}); got != test.want {
+				t.Errorf("acceptsGzip() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
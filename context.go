@@ -0,0 +1,53 @@
+package rbot
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const (
+	contextKeyIdempotencyKey contextKey = iota
+	contextKeyPriority
+)
+
+// Priorities of requests, the server executes the ones with the highest
+// priority first if it has a MaxPriority of at least PriorityInteractive.
+const (
+	PriorityBulk        uint8 = 0
+	PriorityNormal      uint8 = 4
+	PriorityInteractive uint8 = 9
+)
+
+// WithIdempotencyKey returns a copy of ctx making the call it is passed to
+// idempotent: the server executes it once per key and returns the stored
+// result to retries made within Server.IdempotencyWindow.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, contextKeyIdempotencyKey, key)
+}
+
+func idempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(contextKeyIdempotencyKey).(string)
+	return key
+}
+
+// WithPriority returns a copy of ctx giving the call it is passed to
+// priority. Calls default to PriorityNormal, except the answers to queries
+// Telegram expects quickly, which default to PriorityInteractive.
+func WithPriority(ctx context.Context, priority uint8) context.Context {
+	return context.WithValue(ctx, contextKeyPriority, priority)
+}
+
+func priority(ctx context.Context, operation string) uint8 {
+	if priority, ok := ctx.Value(contextKeyPriority).(uint8); ok {
+		return priority
+	}
+
+	switch operation {
+	case OperationAnswerCallbackQuery, OperationAnswerInlineQuery,
+		OperationAnswerShippingQuery, OperationAnswerPreCheckoutQuery:
+		return PriorityInteractive
+	}
+
+	return PriorityNormal
+}
@@ -0,0 +1,210 @@
+package rbot
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	DeadLetterExchangeKind = "fanout"
+
+	// Headers a dead-lettered request is published with.
+	HeaderDeadLetterReason     = "x-rbot-reason"
+	HeaderDeadLetterError      = "x-rbot-error"
+	HeaderDeadLetterExchange   = "x-rbot-exchange"
+	HeaderDeadLetterRoutingKey = "x-rbot-routing-key"
+
+	// Reasons a request is dead-lettered for.
+	DeadLetterUndecodable = "undecodable"
+	DeadLetterFailed      = "failed"
+)
+
+// DeadLetter is a request the server dead-lettered, along with why and where
+// it was originally published to.
+type DeadLetter struct {
+	Delivery   amqp.Delivery
+	Reason     string
+	Error      string
+	Exchange   string
+	RoutingKey string
+}
+
+func newDeadLetter(d amqp.Delivery) DeadLetter {
+	header := func(name string) string {
+		s, _ := d.Headers[name].(string)
+		return s
+	}
+
+	return DeadLetter{
+		Delivery:   d,
+		Reason:     header(HeaderDeadLetterReason),
+		Error:      header(HeaderDeadLetterError),
+		Exchange:   header(HeaderDeadLetterExchange),
+		RoutingKey: header(HeaderDeadLetterRoutingKey),
+	}
+}
+
+// DeclareDeadLetterExchange declares the exchange dead-lettered requests are
+// published to and, if queue is not empty, a queue bound to it keeping them.
+func DeclareDeadLetterExchange(ch *amqp.Channel, exchange string, queue string, durable bool) error {
+	err := ch.ExchangeDeclare(
+		exchange,               // name
+		DeadLetterExchangeKind, // type
+		durable,                // durable
+		false,                  // auto-deleted
+		false,                  // internal
+		false,                  // no-wait
+		nil,                    // arguments
+	)
+	if err != nil {
+		return NewErrorRemoteBot(FailedDeclareExchange, err)
+	}
+
+	if queue == "" {
+		return nil
+	}
+
+	_, err = ch.QueueDeclare(
+		queue,   // name
+		durable, // durable
+		false,   // delete when usused
+		false,   // exclusive
+		false,   // no-wait
+		nil,     // arguments
+	)
+	if err != nil {
+		return NewErrorRemoteBot(FailedDeclareQueue, err)
+	}
+
+	err = ch.QueueBind(
+		queue,    // queue name
+		"",       // routing key
+		exchange, // exchange
+		false,    // no-wait
+		nil,      // arguments
+	)
+	if err != nil {
+		return NewErrorRemoteBot(FailedBindQueue, err)
+	}
+
+	return nil
+}
+
+// PublishDeadLetter publishes d to exchange, unchanged but for the headers
+// describing the failure.
+func PublishDeadLetter(ch *amqp.Channel, exchange string, d amqp.Delivery, reason string, cause error) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderDeadLetterReason] = reason
+	headers[HeaderDeadLetterError] = cause.Error()
+	headers[HeaderDeadLetterExchange] = d.Exchange
+	headers[HeaderDeadLetterRoutingKey] = d.RoutingKey
+
+	err := ch.Publish(
+		exchange, // exchange
+		"",       // routing key
+		false,    // mandatory
+		false,    // immediate
+		amqp.Publishing{
+			Headers:         headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    d.DeliveryMode,
+			CorrelationId:   d.CorrelationId,
+			ReplyTo:         d.ReplyTo,
+			Body:            d.Body,
+		})
+	if err != nil {
+		return NewErrorRemoteBot(FailedMessagePublish, err)
+	}
+
+	return nil
+}
+
+// InspectDeadLetters returns up to max dead-lettered requests from queue
+// without removing them from it.
+func InspectDeadLetters(ch *amqp.Channel, queue string, max int) ([]DeadLetter, error) {
+	var deadLetters []DeadLetter
+
+	// The deliveries stay unacknowledged until all of them are fetched, so
+	// the same one is not returned twice.
+	defer func() {
+		for _, deadLetter := range deadLetters {
+			deadLetter.Delivery.Nack(false, true)
+		}
+	}()
+
+	for len(deadLetters) < max {
+		d, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return nil, NewErrorRemoteBot(FailedMessageConsume, err)
+		}
+
+		if !ok {
+			break
+		}
+
+		deadLetters = append(deadLetters, newDeadLetter(d))
+	}
+
+	return deadLetters, nil
+}
+
+// RequeueDeadLetters publishes up to max dead-lettered requests from queue
+// back where they were originally published to, without the dead-letter
+// headers, and returns how many were requeued. Their client deadline is
+// dropped too, it has usually passed and the server would skip them.
+func RequeueDeadLetters(ch *amqp.Channel, queue string, max int) (int, error) {
+	for n := 0; n < max; n++ {
+		d, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return n, NewErrorRemoteBot(FailedMessageConsume, err)
+		}
+
+		if !ok {
+			return n, nil
+		}
+
+		deadLetter := newDeadLetter(d)
+		if deadLetter.Reason == "" {
+			d.Nack(false, true)
+			return n, NewErrorRemoteBot(FailedMessagePublish, fmt.Errorf("not a dead letter"))
+		}
+
+		headers := amqp.Table{}
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+		delete(headers, HeaderDeadLetterReason)
+		delete(headers, HeaderDeadLetterError)
+		delete(headers, HeaderDeadLetterExchange)
+		delete(headers, HeaderDeadLetterRoutingKey)
+		delete(headers, HeaderDeadline)
+
+		err = ch.Publish(
+			deadLetter.Exchange,   // exchange
+			deadLetter.RoutingKey, // routing key
+			false,                 // mandatory
+			false,                 // immediate
+			amqp.Publishing{
+				Headers:         headers,
+				ContentType:     d.ContentType,
+				ContentEncoding: d.ContentEncoding,
+				DeliveryMode:    d.DeliveryMode,
+				CorrelationId:   d.CorrelationId,
+				ReplyTo:         d.ReplyTo,
+				Body:            d.Body,
+			})
+		if err != nil {
+			d.Nack(false, true)
+			return n, NewErrorRemoteBot(FailedMessagePublish, err)
+		}
+
+		d.Ack(false)
+	}
+
+	return max, nil
+}
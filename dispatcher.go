@@ -0,0 +1,236 @@
+package rbot
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// job is a delivery together with its decoded request, the codec and
+// protocol version to reply with, the name of the bot it is for and the chat
+// it targets, empty when the operation has no chat.
+type job struct {
+	d       amqp.Delivery
+	n       RequestMessage
+	codec   Codec
+	version int
+	err     error
+	bot     string
+	key     string
+
+	// reserved is set while j is delayed to its rate limited slot, floodWait
+	// is the reply of the call j is delayed to retry for flood control.
+	reserved  bool
+	floodWait *ResponseMessage
+}
+
+// chatQueues serializes the jobs sharing a chat key: while one of them runs,
+// the next ones wait in the queue of their chat and are run in order by the
+// worker of the running one.
+type chatQueues struct {
+	mutex   sync.Mutex
+	queues  map[string][]*job
+	stopped bool
+}
+
+func newChatQueues() *chatQueues {
+	return &chatQueues{
+		queues: make(map[string][]*job),
+	}
+}
+
+// acquire reports whether j can run now. Otherwise j was queued behind the
+// running job of its chat.
+func (c *chatQueues) acquire(j *job) bool {
+	if j.key == "" {
+		return true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	queue, busy := c.queues[j.key]
+	if busy {
+		c.queues[j.key] = append(queue, j)
+		return false
+	}
+
+	c.queues[j.key] = nil
+	return true
+}
+
+// next returns the job to run after j finished, nil when its chat has no
+// more queued jobs.
+func (c *chatQueues) next(j *job) *job {
+	if j.key == "" {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	queue := c.queues[j.key]
+	if c.stopped || len(queue) == 0 {
+		delete(c.queues, j.key)
+		return nil
+	}
+
+	c.queues[j.key] = queue[1:]
+	return queue[0]
+}
+
+// chatKey returns the chat targeted by a request, or an empty string if it
+// targets none.
+func chatKey(n *RequestMessage) string {
+	switch n.Operation {
+	case OperationSend:
+		// Chattables are stored in the ValueXxx field matching their type.
+		name := "Value" + n.C.Type[strings.LastIndex(n.C.Type, ".")+1:]
+		return configChatKey(reflect.ValueOf(n.C).FieldByName(name))
+	case OperationKickChatMember:
+		return configChatKey(reflect.ValueOf(n.Config7))
+	case OperationLeaveChat, OperationGetChat, OperationGetChatAdministrators,
+		OperationGetChatMembersCount, OperationGetInviteLink:
+		return configChatKey(reflect.ValueOf(n.Config8))
+	case OperationGetChatMember:
+		return configChatKey(reflect.ValueOf(n.Config9))
+	case OperationUnbanChatMember:
+		return configChatKey(reflect.ValueOf(n.Config10))
+	case OperationRestrictChatMember:
+		return configChatKey(reflect.ValueOf(n.Config11))
+	case OperationPromoteChatMember:
+		return configChatKey(reflect.ValueOf(n.Config12))
+	case OperationDeleteMessage:
+		return configChatKey(reflect.ValueOf(n.Config16))
+	case OperationPinChatMessage:
+		return configChatKey(reflect.ValueOf(n.Config17))
+	case OperationUnpinChatMessage:
+		return configChatKey(reflect.ValueOf(n.Config18))
+	case OperationSetChatTitle:
+		return configChatKey(reflect.ValueOf(n.Config19))
+	case OperationSetChatDescription:
+		return configChatKey(reflect.ValueOf(n.Config20))
+	case OperationSetChatPhoto:
+		return configChatKey(reflect.ValueOf(n.Config21))
+	case OperationDeleteChatPhoto:
+		return configChatKey(reflect.ValueOf(n.Config22))
+	}
+
+	return ""
+}
+
+// configChatKey reads the chat of a tgbotapi config from its ChatID,
+// ChannelUsername or SuperGroupUsername fields, embedded ones included.
+func configChatKey(v reflect.Value) string {
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return ""
+	}
+
+	for _, name := range []string{"ChannelUsername", "SuperGroupUsername"} {
+		f := v.FieldByName(name)
+		if f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+			return f.String()
+		}
+	}
+
+	f := v.FieldByName("ChatID")
+	if f.IsValid() {
+		switch f.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			if f.Int() != 0 {
+				return strconv.FormatInt(f.Int(), 10)
+			}
+		}
+	}
+
+	return ""
+}
+
+// stop makes next return nil from now on and returns the queued jobs, which
+// will not be run.
+func (c *chatQueues) stop() []*job {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var jobs []*job
+	for key, queue := range c.queues {
+		jobs = append(jobs, queue...)
+		c.queues[key] = nil
+	}
+	c.stopped = true
+
+	return jobs
+}
+
+// delayedJobs hands jobs back to the dispatcher once they are due, so the jobs
+// waiting for the rate limits or to retry a flood wait do not hold a worker. A
+// delayed job keeps its chat busy until it is run again.
+type delayedJobs struct {
+	due chan *job
+
+	mutex   sync.Mutex
+	timers  map[*job]*time.Timer
+	stopped bool
+	done    chan struct{}
+}
+
+func newDelayedJobs() *delayedJobs {
+	return &delayedJobs{
+		due:    make(chan *job),
+		timers: make(map[*job]*time.Timer),
+		done:   make(chan struct{}),
+	}
+}
+
+// add hands j back on due at the time at, or requeues it if stopped by then.
+func (d *delayedJobs) add(j *job, at time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.stopped {
+		j.d.Nack(false, true)
+		return
+	}
+
+	d.timers[j] = time.AfterFunc(time.Until(at), func() {
+		d.mutex.Lock()
+		_, ok := d.timers[j]
+		delete(d.timers, j)
+		d.mutex.Unlock()
+
+		// Otherwise stop returned j.
+		if !ok {
+			return
+		}
+
+		select {
+		case d.due <- j:
+		case <-d.done:
+			j.d.Nack(false, true)
+		}
+	})
+}
+
+// stop returns the jobs not handed back yet, which will not be anymore.
+func (d *delayedJobs) stop() []*job {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var jobs []*job
+	for j, timer := range d.timers {
+		timer.Stop()
+		jobs = append(jobs, j)
+		delete(d.timers, j)
+	}
+
+	if !d.stopped {
+		d.stopped = true
+		close(d.done)
+	}
+
+	return jobs
+}
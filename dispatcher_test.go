@@ -0,0 +1,162 @@
+package rbot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestChatQueuesSameKey(t *testing.T) {
+	c := newChatQueues()
+	first, second, third := &job{key: "1"}, &job{key: "1"}, &job{key: "1"}
+
+	if !c.acquire(first) {
+		t.Fatal("acquire(first) = false, want true")
+	}
+
+	if c.acquire(second) || c.acquire(third) {
+		t.Fatal("acquire() of a busy chat = true, want false")
+	}
+
+	if got := c.next(first); got != second {
+		t.Errorf("next(first) = %p, want second %p", got, second)
+	}
+
+	if got := c.next(second); got != third {
+		t.Errorf("next(second) = %p, want third %p", got, third)
+	}
+
+	if got := c.next(third); got != nil {
+		t.Errorf("next(third) = %p, want nil", got)
+	}
+
+	if !c.acquire(&job{key: "1"}) {
+		t.Error("acquire() after the queue drained = false, want true")
+	}
+}
+
+func TestChatQueuesDifferentKeys(t *testing.T) {
+	c := newChatQueues()
+	first, second := &job{key: "1"}, &job{key: "2"}
+
+	if !c.acquire(first) || !c.acquire(second) {
+		t.Fatal("acquire() of different chats = false, want true")
+	}
+
+	if got := c.next(first); got != nil {
+		t.Errorf("next(first) = %p, want nil", got)
+	}
+
+	if got := c.next(second); got != nil {
+		t.Errorf("next(second) = %p, want nil", got)
+	}
+
+	// Jobs without a chat are never serialized.
+	if !c.acquire(&job{}) || !c.acquire(&job{}) {
+		t.Error("acquire() without a chat = false, want true")
+	}
+}
+
+func TestChatQueuesStop(t *testing.T) {
+	c := newChatQueues()
+	running, queued := &job{key: "1"}, &job{key: "1"}
+
+	c.acquire(running)
+	c.acquire(queued)
+
+	jobs := c.stop()
+	if len(jobs) != 1 || jobs[0] != queued {
+		t.Errorf("stop() = %v, want the queued job", jobs)
+	}
+
+	if got := c.next(running); got != nil {
+		t.Errorf("next() after stop() = %p, want nil", got)
+	}
+}
+
+func TestDelayedJobs(t *testing.T) {
+	d := newDelayedJobs()
+	soon, later := &job{key: "1"}, &job{key: "2"}
+
+	d.add(soon, time.Now().Add(10*time.Millisecond))
+	d.add(later, time.Now().Add(time.Hour))
+
+	select {
+	case got := <-d.due:
+		if got != soon {
+			t.Errorf("due = %p, want soon %p", got, soon)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("due job not handed back")
+	}
+
+	jobs := d.stop()
+	if len(jobs) != 1 || jobs[0] != later {
+		t.Errorf("stop() = %v, want the later job", jobs)
+	}
+}
+
+func TestChatKey(t *testing.T) {
+	tests := []struct {
+		name string
+		n    RequestMessage
+		want string
+	}{
+		{
+			"message",
+			RequestMessage{Operation: OperationSend, C: NewConcreteChattable(tgbotapi.NewMessage(42, "text"))},
+			"42",
+		},
+		{
+			"message to a channel username",
+			RequestMessage{Operation: OperationSend, C: NewConcreteChattable(tgbotapi.NewMessageToChannel("@channel", "text"))},
+			"@channel",
+		},
+		{
+			"edit message text",
+			RequestMessage{Operation: OperationSend, C: NewConcreteChattable(tgbotapi.NewEditMessageText(-42, 1, "text"))},
+			"-42",
+		},
+		{
+			"kick chat member",
+			RequestMessage{Operation: OperationKickChatMember, Config7: tgbotapi.KickChatMemberConfig{
+				ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: -42, UserID: 1},
+			}},
+			"-42",
+		},
+		{
+			"kick chat member of a channel username",
+			RequestMessage{Operation: OperationKickChatMember, Config7: tgbotapi.KickChatMemberConfig{
+				ChatMemberConfig: tgbotapi.ChatMemberConfig{ChannelUsername: "@channel", UserID: 1},
+			}},
+			"@channel",
+		},
+		{
+			"get chat of a supergroup username",
+			RequestMessage{Operation: OperationGetChat, Config8: tgbotapi.ChatConfig{SuperGroupUsername: "@group"}},
+			"@group",
+		},
+		{
+			"without chat",
+			RequestMessage{Operation: OperationGetMe},
+			"",
+		},
+		{
+			"inline message",
+			RequestMessage{Operation: OperationSend, C: NewConcreteChattable(tgbotapi.EditMessageTextConfig{
+				BaseEdit: tgbotapi.BaseEdit{InlineMessageID: "inline"},
+				Text:     "text",
+			})},
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := chatKey(&test.n); got != test.want {
+				t.Errorf("chatKey() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
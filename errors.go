@@ -2,6 +2,9 @@ package rbot
 
 import (
 	"fmt"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
 type ErrorString struct {
@@ -25,6 +28,61 @@ func (e *ErrorRemoteBot) Error() string {
 	return e.s
 }
 
+// Unwrap returns the cause of e, e.g. context.DeadlineExceeded when a call
+// timed out.
+func (e *ErrorRemoteBot) Unwrap() error {
+	return e.i
+}
+
 func NewErrorRemoteBot(s string, e error) error {
 	return &ErrorRemoteBot{s, e}
 }
+
+// ErrorTelegram is a call Telegram rejected. It unwraps to the tgbotapi.Error
+// the server got.
+type ErrorTelegram struct {
+	Code        int
+	Description string
+	Parameters  tgbotapi.ResponseParameters
+}
+
+func (e *ErrorTelegram) Error() string {
+	return e.Description
+}
+
+func (e *ErrorTelegram) Unwrap() error {
+	return tgbotapi.Error{Message: e.Description, ResponseParameters: e.Parameters}
+}
+
+// ErrorFloodWait is returned when Telegram rejected a call because the bot
+// made too many requests, and the server could not retry it before the
+// deadline of the call. It unwraps to the ErrorTelegram, nil from servers
+// predating it.
+type ErrorFloodWait struct {
+	Message    string
+	RetryAfter time.Duration
+	Telegram   *ErrorTelegram
+}
+
+func (e *ErrorFloodWait) Error() string {
+	return e.Message
+}
+
+func (e *ErrorFloodWait) Unwrap() error {
+	if e.Telegram == nil {
+		return nil
+	}
+
+	return e.Telegram
+}
+
+// ErrorRemote is an error of the server that is not from Telegram, of kind
+// ErrorKindTransport or ErrorKindInternal.
+type ErrorRemote struct {
+	Kind    string
+	Message string
+}
+
+func (e *ErrorRemote) Error() string {
+	return e.Message
+}
@@ -1,5 +1,7 @@
 module github.com/tinti/remote-telegram-bot-api
 
+go 1.13
+
 require (
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
 	github.com/streadway/amqp v0.0.0-20181107104731-27835f1a64e9
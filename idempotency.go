@@ -0,0 +1,72 @@
+package rbot
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultIdempotencyWindow = 10 * time.Minute
+)
+
+// IdempotencyStore keeps the responses of idempotent requests by key, it must
+// be safe for concurrent use. Implementations backed by a shared database
+// let several servers consuming the same queue deduplicate requests.
+type IdempotencyStore interface {
+	// Get returns the response stored for key, if it has not expired.
+	Get(key string) (ResponseMessage, bool)
+
+	// Put stores the response of key for ttl.
+	Put(key string, r ResponseMessage, ttl time.Duration)
+}
+
+type memoryIdempotencyEntry struct {
+	r       ResponseMessage
+	expires time.Time
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore keeping the responses in
+// memory, they are lost when the server stops.
+type MemoryIdempotencyStore struct {
+	mutex     sync.Mutex
+	entries   map[string]memoryIdempotencyEntry
+	lastSweep time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		entries: make(map[string]memoryIdempotencyEntry),
+	}
+}
+
+func (m *MemoryIdempotencyStore) Get(key string) (ResponseMessage, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return ResponseMessage{}, false
+	}
+
+	return entry.r, true
+}
+
+func (m *MemoryIdempotencyStore) Put(key string, r ResponseMessage, ttl time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	m.entries[key] = memoryIdempotencyEntry{r, now.Add(ttl)}
+
+	// Expired entries are removed at most once a minute.
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+
+	for key, entry := range m.entries {
+		if now.After(entry.expires) {
+			delete(m.entries, key)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package rbot
+
+import (
+	"fmt"
+)
+
+// DialOption configures a RemoteBotAPI created by RemoteBotDial.
+type DialOption func(*dialOptions)
+
+type dialOptions struct {
+	directReplyTo bool
+	bot           string
+	reliable      bool
+
+	protocolVersion int
+	codec           Codec
+
+	compressionThreshold int
+
+	errorHandler func(error)
+
+	checkCompatibility bool
+	requiredFeatures   []string
+
+	requestQueue    string
+	exchange        string
+	routingKey      string
+	updatesExchange string
+	webhookExchange string
+}
+
+func newDialOptions(options []DialOption) dialOptions {
+	o := dialOptions{
+		protocolVersion: ProtocolVersion,
+		codec:           JSONCodec,
+		errorHandler:    func(error) {},
+		requestQueue:    RoutingKey,
+		updatesExchange: UpdatesExchange,
+		webhookExchange: WebhookExchange,
+	}
+	for _, option := range options {
+		option(&o)
+	}
+
+	return o
+}
+
+// WithDirectReplyTo makes the client receive replies through RabbitMQ's
+// amq.rabbitmq.reply-to pseudo-queue instead of declaring its own reply
+// queue, for brokers where clients are not allowed to declare queues.
+func WithDirectReplyTo() DialOption {
+	return func(o *dialOptions) {
+		o.directReplyTo = true
+	}
+}
+
+// validate reports combinations of options the server cannot decode.
+func (o *dialOptions) validate() error {
+	if o.protocolVersion < LegacyProtocolVersion || o.protocolVersion > ProtocolVersion {
+		return NewErrorRemoteBot(FailedDialOption, fmt.Errorf("unsupported protocol version %d", o.protocolVersion))
+	}
+
+	if o.codec == nil {
+		return NewErrorRemoteBot(FailedDialOption, fmt.Errorf("no codec"))
+	}
+
+	if o.errorHandler == nil {
+		return NewErrorRemoteBot(FailedDialOption, fmt.Errorf("no error handler"))
+	}
+
+	// Legacy messages are told apart from envelopes as JSON only.
+	if o.protocolVersion == LegacyProtocolVersion && o.codec != JSONCodec {
+		return NewErrorRemoteBot(FailedDialOption, fmt.Errorf("protocol version %d requires JSONCodec, not %s", o.protocolVersion, o.codec.ContentType()))
+	}
+
+	return nil
+}
+
+// requestKey is the routing key requests are published with. Without a
+// routing key it is the request queue name, as the default exchange expects.
+func (o *dialOptions) requestKey() string {
+	if o.routingKey != "" {
+		return o.routingKey
+	}
+
+	return o.requestQueue
+}
+
+// WithRequestQueue sets the name of the queue the server consumes requests
+// from. It must match the QueueName of the Server.
+func WithRequestQueue(name string) DialOption {
+	return func(o *dialOptions) {
+		o.requestQueue = name
+	}
+}
+
+// WithExchange publishes requests to exchange instead of the default one.
+// It must match the Exchange of the Server.
+func WithExchange(exchange string) DialOption {
+	return func(o *dialOptions) {
+		o.exchange = exchange
+	}
+}
+
+// WithRoutingKey sets the routing key requests are published with. It must
+// match the RoutingKey of the Server.
+func WithRoutingKey(key string) DialOption {
+	return func(o *dialOptions) {
+		o.routingKey = key
+	}
+}
+
+// WithUpdatesExchanges sets the exchanges GetUpdatesChan and
+// ListenForWebhook consume from. They must match the UpdatesExchange and
+// WebhookExchange of the Server.
+func WithUpdatesExchanges(updatesExchange string, webhookExchange string) DialOption {
+	return func(o *dialOptions) {
+		o.updatesExchange = updatesExchange
+		o.webhookExchange = webhookExchange
+	}
+}
+
+// WithBot binds the client to the bot called name on a Server hosting
+// several bots.
+func WithBot(name string) DialOption {
+	return func(o *dialOptions) {
+		o.bot = name
+	}
+}
+
+// WithReliableDelivery publishes requests as persistent messages and waits
+// for the broker to confirm each of them before waiting for the reply. Pair
+// it with a Durable Server so queued requests survive a broker restart.
+func WithReliableDelivery() DialOption {
+	return func(o *dialOptions) {
+		o.reliable = true
+	}
+}
+
+// WithProtocolVersion makes the client encode requests in the format of
+// version, LegacyProtocolVersion for servers predating Envelope. Servers
+// reply in the format of the request.
+func WithProtocolVersion(version int) DialOption {
+	return func(o *dialOptions) {
+		o.protocolVersion = version
+	}
+}
+
+// WithCompatibilityCheck makes RemoteBotDial fail unless the server supports
+// GetCapabilities, speaks the protocol version of the client and has all of
+// features enabled.
+func WithCompatibilityCheck(features ...string) DialOption {
+	return func(o *dialOptions) {
+		o.checkCompatibility = true
+		o.requiredFeatures = features
+	}
+}
+
+// WithCodec makes the client encode requests with codec, the server replies
+// with the same codec.
+func WithCodec(codec Codec) DialOption {
+	return func(o *dialOptions) {
+		o.codec = codec
+	}
+}
+
+// WithCompression makes the client gzip the requests longer than threshold
+// bytes. Servers predating compression cannot decode them. Replies are
+// compressed according to the configuration of the server.
+func WithCompression(threshold int) DialOption {
+	return func(o *dialOptions) {
+		o.compressionThreshold = threshold
+	}
+}
+
+// WithErrorHandler makes the client report to handler the errors it recovers
+// from on its own, like a lost connection and the failed attempts to dial
+// again.
+func WithErrorHandler(handler func(error)) DialOption {
+	return func(o *dialOptions) {
+		o.errorHandler = handler
+	}
+}
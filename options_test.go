@@ -0,0 +1,39 @@
+package rbot
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDialOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []DialOption
+		valid   bool
+	}{
+		{"defaults", nil, true},
+		{"legacy", []DialOption{WithProtocolVersion(LegacyProtocolVersion)}, true},
+		{"gob", []DialOption{WithCodec(GobCodec)}, true},
+		{"legacy gob", []DialOption{WithProtocolVersion(LegacyProtocolVersion), WithCodec(GobCodec)}, false},
+		{"unknown version", []DialOption{WithProtocolVersion(ProtocolVersion + 1)}, false},
+		{"no version", []DialOption{WithProtocolVersion(0)}, false},
+		{"no codec", []DialOption{WithCodec(nil)}, false},
+		{"no error handler", []DialOption{WithErrorHandler(nil)}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := newDialOptions(test.options)
+
+			err := o.validate()
+			if (err == nil) != test.valid {
+				t.Fatalf("validate() error = %v, want valid %t", err, test.valid)
+			}
+
+			var remoteBotErr *ErrorRemoteBot
+			if err != nil && (!errors.As(err, &remoteBotErr) || remoteBotErr.s != FailedDialOption) {
+				t.Errorf("validate() error = %v, want a %q ErrorRemoteBot", err, FailedDialOption)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package rbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+const (
+	// LegacyProtocolVersion is the format of servers and clients predating
+	// Envelope: RequestMessage and ResponseMessage encoded as they are.
+	LegacyProtocolVersion = 1
+	ProtocolVersion       = 2
+)
+
+// Envelope is the wire format of requests and responses. Payload holds the
+// arguments of the operation, or its result, and Error is nil on success.
+type Envelope struct {
+	Version        int             `json:"version"`
+	Operation      string          `json:"op"`
+	CorrelationId  string          `json:"id"`
+	Bot            string          `json:"bot,omitempty"`
+	IdempotencyKey string          `json:"idempotency_key,omitempty"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+	Error          *ConcreteError  `json:"error,omitempty"`
+}
+
+// requestFields are the RequestMessage fields holding the payload of each
+// operation, for the ones with a single argument.
+var requestFields = map[string]string{
+	OperationGetFileDirectURL:       "FileID",
+	OperationIsMessageToMe:          "Message",
+	OperationGetUserProfilePhotos:   "Config",
+	OperationGetFile:                "Config2",
+	OperationGetUpdates:             "Config3",
+	OperationSetWebhook:             "Config4",
+	OperationGetUpdatesChan:         "Config3",
+	OperationListenForWebhook:       "Pattern",
+	OperationAnswerInlineQuery:      "Config5",
+	OperationAnswerCallbackQuery:    "Config6",
+	OperationKickChatMember:         "Config7",
+	OperationLeaveChat:              "Config8",
+	OperationGetChat:                "Config8",
+	OperationGetChatAdministrators:  "Config8",
+	OperationGetChatMembersCount:    "Config8",
+	OperationGetChatMember:          "Config9",
+	OperationUnbanChatMember:        "Config10",
+	OperationRestrictChatMember:     "Config11",
+	OperationPromoteChatMember:      "Config12",
+	OperationGetGameHighScores:      "Config13",
+	OperationAnswerShippingQuery:    "Config14",
+	OperationAnswerPreCheckoutQuery: "Config15",
+	OperationDeleteMessage:          "Config16",
+	OperationGetInviteLink:          "Config8",
+	OperationPinChatMessage:         "Config17",
+	OperationUnpinChatMessage:       "Config18",
+	OperationSetChatTitle:           "Config19",
+	OperationSetChatDescription:     "Config20",
+	OperationSetChatPhoto:           "Config21",
+	OperationDeleteChatPhoto:        "Config22",
+}
+
+// responseFields are the ResponseMessage fields holding the result of each
+// operation returning something else than a tgbotapi.APIResponse.
+var responseFields = map[string]string{
+	OperationGetFileDirectURL:      "R3",
+	OperationGetMe:                 "R4",
+	OperationIsMessageToMe:         "R5",
+	OperationSend:                  "R6",
+	OperationGetUserProfilePhotos:  "R7",
+	OperationGetFile:               "R8",
+	OperationGetUpdates:            "R9",
+	OperationGetWebhookInfo:        "R10",
+	OperationGetUpdatesChan:        "",
+	OperationListenForWebhook:      "",
+	OperationGetChat:               "R12",
+	OperationGetChatAdministrators: "R13",
+	OperationGetChatMembersCount:   "R14",
+	OperationGetChatMember:         "R15",
+	OperationGetGameHighScores:     "R16",
+	OperationGetInviteLink:         "R3",
+	OperationGetCapabilities:       "R17",
+}
+
+type makeRequestPayload struct {
+	Endpoint string     `json:"endpoint"`
+	Params   url.Values `json:"params"`
+}
+
+type uploadFilePayload struct {
+	Endpoint  string            `json:"endpoint"`
+	Params    map[string]string `json:"params"`
+	Fieldname string            `json:"fieldname"`
+	File      interface{}       `json:"file"`
+}
+
+// sendPayload is a Chattable with its type, as in ConcreteChattable.
+type sendPayload struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// chattableField returns the ConcreteChattable field holding the Chattable of
+// type t.
+func chattableField(c *ConcreteChattable, t string) (reflect.Value, error) {
+	f := reflect.ValueOf(c).Elem().FieldByName("Value" + t[strings.LastIndex(t, ".")+1:])
+	if !f.IsValid() {
+		return f, fmt.Errorf("unknown chattable %q", t)
+	}
+
+	return f, nil
+}
+
+// EncodeRequest encodes n with codec in the format of version.
+func EncodeRequest(codec Codec, n *RequestMessage, version int) ([]byte, error) {
+	if version == LegacyProtocolVersion {
+		return codec.Marshal(*n)
+	}
+
+	var payload interface{}
+	switch n.Operation {
+	case OperationMakeRequest:
+		payload = makeRequestPayload{n.Endpoint, n.Params}
+	case OperationUploadFile:
+		payload = uploadFilePayload{n.Endpoint, n.Params2, n.Fieldname, n.File}
+	case OperationSend:
+		f, err := chattableField(&n.C, n.C.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := codec.Marshal(f.Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		payload = sendPayload{n.C.Type, value}
+	default:
+		if name, ok := requestFields[n.Operation]; ok {
+			payload = reflect.ValueOf(n).Elem().FieldByName(name).Interface()
+		}
+	}
+
+	e := Envelope{
+		Version:        ProtocolVersion,
+		Operation:      n.Operation,
+		CorrelationId:  n.CorrelationId,
+		Bot:            n.Bot,
+		IdempotencyKey: n.IdempotencyKey,
+	}
+
+	if payload != nil {
+		raw, err := codec.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		e.Payload = raw
+	}
+
+	return codec.Marshal(e)
+}
+
+// DecodeRequest decodes a request encoded with codec in any supported
+// format, returning the version it was encoded with.
+func DecodeRequest(codec Codec, body []byte) (RequestMessage, int, error) {
+	var n RequestMessage
+
+	version, err := protocolVersion(codec, body)
+	if err != nil {
+		return n, LegacyProtocolVersion, err
+	}
+
+	if version == LegacyProtocolVersion {
+		return n, version, codec.Unmarshal(body, &n)
+	}
+
+	var e Envelope
+	err = codec.Unmarshal(body, &e)
+	if err != nil {
+		return n, version, err
+	}
+
+	n.Operation, n.CorrelationId = e.Operation, e.CorrelationId
+	n.Bot, n.IdempotencyKey = e.Bot, e.IdempotencyKey
+
+	if len(e.Payload) == 0 {
+		return n, version, nil
+	}
+
+	switch n.Operation {
+	case OperationMakeRequest:
+		var p makeRequestPayload
+		err = codec.Unmarshal(e.Payload, &p)
+		n.Endpoint, n.Params = p.Endpoint, p.Params
+	case OperationUploadFile:
+		var p uploadFilePayload
+		err = codec.Unmarshal(e.Payload, &p)
+		n.Endpoint, n.Params2, n.Fieldname, n.File = p.Endpoint, p.Params, p.Fieldname, p.File
+	case OperationSend:
+		var p sendPayload
+		err = codec.Unmarshal(e.Payload, &p)
+		if err != nil {
+			return n, version, err
+		}
+
+		n.C.Type = p.Type
+
+		var f reflect.Value
+		f, err = chattableField(&n.C, p.Type)
+		if err != nil {
+			return n, version, err
+		}
+
+		err = codec.Unmarshal(p.Value, f.Addr().Interface())
+	default:
+		if name, ok := requestFields[n.Operation]; ok {
+			err = codec.Unmarshal(e.Payload, reflect.ValueOf(&n).Elem().FieldByName(name).Addr().Interface())
+		}
+	}
+
+	return n, version, err
+}
+
+// EncodeResponse encodes r with codec in the format of version.
+func EncodeResponse(codec Codec, r *ResponseMessage, version int) ([]byte, error) {
+	if version == LegacyProtocolVersion {
+		return codec.Marshal(*r)
+	}
+
+	e := Envelope{
+		Version:       ProtocolVersion,
+		Operation:     r.Operation,
+		CorrelationId: r.CorrelationId,
+	}
+
+	if !r.R2.IsNil {
+		concreteError := r.R2
+		e.Error = &concreteError
+	}
+
+	name, ok := responseFields[r.Operation]
+	if !ok {
+		name = "R"
+	}
+
+	if name != "" {
+		raw, err := codec.Marshal(reflect.ValueOf(r).Elem().FieldByName(name).Interface())
+		if err != nil {
+			return nil, err
+		}
+		e.Payload = raw
+	}
+
+	return codec.Marshal(e)
+}
+
+// DecodeResponse decodes a response encoded with codec in any supported
+// format, returning the version it was encoded with.
+func DecodeResponse(codec Codec, body []byte) (ResponseMessage, int, error) {
+	var r ResponseMessage
+
+	version, err := protocolVersion(codec, body)
+	if err != nil {
+		return r, LegacyProtocolVersion, err
+	}
+
+	if version == LegacyProtocolVersion {
+		return r, version, codec.Unmarshal(body, &r)
+	}
+
+	var e Envelope
+	err = codec.Unmarshal(body, &e)
+	if err != nil {
+		return r, version, err
+	}
+
+	r.Operation, r.CorrelationId = e.Operation, e.CorrelationId
+
+	r.R2 = NewConcreteError(nil)
+	if e.Error != nil {
+		r.R2 = *e.Error
+	}
+
+	name, ok := responseFields[r.Operation]
+	if !ok {
+		name = "R"
+	}
+
+	if name != "" && len(e.Payload) > 0 {
+		err = codec.Unmarshal(e.Payload, reflect.ValueOf(&r).Elem().FieldByName(name).Addr().Interface())
+	}
+
+	return r, version, err
+}
+
+// protocolVersion returns the version of an encoded message, legacy ones
+// have none. Only JSON messages can be legacy ones.
+func protocolVersion(codec Codec, body []byte) (int, error) {
+	var probe struct {
+		Version int `json:"version"`
+	}
+
+	err := codec.Unmarshal(body, &probe)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case probe.Version == 0:
+		return LegacyProtocolVersion, nil
+	case probe.Version > ProtocolVersion:
+		return 0, fmt.Errorf("unsupported protocol version %d", probe.Version)
+	}
+
+	return probe.Version, nil
+}
@@ -0,0 +1,225 @@
+package rbot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+var protocolFormats = []struct {
+	name    string
+	codec   Codec
+	version int
+}{
+	{"v1/json", JSONCodec, LegacyProtocolVersion},
+	{"v2/json", JSONCodec, ProtocolVersion},
+	{"v2/gob", GobCodec, ProtocolVersion},
+}
+
+// testRequests has a request of every operation, with its arguments set.
+var testRequests = []RequestMessage{
+	{Operation: OperationMakeRequest, Endpoint: "getMe", Params: url.Values{"a": {"1", "2"}}},
+	{Operation: OperationUploadFile, Endpoint: "sendPhoto", Params2: map[string]string{"chat_id": "1"}, Fieldname: "photo", File: "photo.jpg"},
+	{Operation: OperationGetFileDirectURL, FileID: "file"},
+	{Operation: OperationGetMe},
+	{Operation: OperationIsMessageToMe, Message: tgbotapi.Message{MessageID: 1, Text: "/start@bot", Chat: &tgbotapi.Chat{ID: 1}}},
+	{Operation: OperationSend, C: NewConcreteChattable(tgbotapi.NewMessage(1, "text"))},
+	{Operation: OperationGetUserProfilePhotos, Config: tgbotapi.UserProfilePhotosConfig{UserID: 1, Offset: 2, Limit: 3}},
+	{Operation: OperationGetFile, Config2: tgbotapi.FileConfig{FileID: "file"}},
+	{Operation: OperationGetUpdates, Config3: tgbotapi.UpdateConfig{Offset: 1, Limit: 2, Timeout: 3}},
+	{Operation: OperationRemoveWebhook},
+	{Operation: OperationSetWebhook, Config4: tgbotapi.WebhookConfig{URL: &url.URL{Scheme: "https", Host: "example.com", Path: "/hook"}, MaxConnections: 1}},
+	{Operation: OperationGetWebhookInfo},
+	{Operation: OperationGetUpdatesChan, Config3: tgbotapi.UpdateConfig{Offset: 1, Limit: 2, Timeout: 3}},
+	{Operation: OperationListenForWebhook, Pattern: "/hook"},
+	{Operation: OperationAnswerInlineQuery, Config5: tgbotapi.InlineConfig{InlineQueryID: "query", CacheTime: 1}},
+	{Operation: OperationAnswerCallbackQuery, Config6: tgbotapi.CallbackConfig{CallbackQueryID: "query", Text: "text"}},
+	{Operation: OperationKickChatMember, Config7: tgbotapi.KickChatMemberConfig{ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: -1, UserID: 2}, UntilDate: 3}},
+	{Operation: OperationLeaveChat, Config8: tgbotapi.ChatConfig{ChatID: -1}},
+	{Operation: OperationGetChat, Config8: tgbotapi.ChatConfig{SuperGroupUsername: "@group"}},
+	{Operation: OperationGetChatAdministrators, Config8: tgbotapi.ChatConfig{ChatID: -1}},
+	{Operation: OperationGetChatMembersCount, Config8: tgbotapi.ChatConfig{ChatID: -1}},
+	{Operation: OperationGetChatMember, Config9: tgbotapi.ChatConfigWithUser{ChatID: -1, UserID: 2}},
+	{Operation: OperationUnbanChatMember, Config10: tgbotapi.ChatMemberConfig{ChatID: -1, UserID: 2}},
+	{Operation: OperationRestrictChatMember, Config11: tgbotapi.RestrictChatMemberConfig{ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: -1, UserID: 2}, UntilDate: 3}},
+	{Operation: OperationPromoteChatMember, Config12: tgbotapi.PromoteChatMemberConfig{ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: -1, UserID: 2}}},
+	{Operation: OperationGetGameHighScores, Config13: tgbotapi.GetGameHighScoresConfig{UserID: 1, ChatID: 2, MessageID: 3}},
+	{Operation: OperationAnswerShippingQuery, Config14: tgbotapi.ShippingConfig{ShippingQueryID: "query", OK: true}},
+	{Operation: OperationAnswerPreCheckoutQuery, Config15: tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: "query", ErrorMessage: "error"}},
+	{Operation: OperationDeleteMessage, Config16: tgbotapi.DeleteMessageConfig{ChatID: 1, MessageID: 2}},
+	{Operation: OperationGetInviteLink, Config8: tgbotapi.ChatConfig{ChatID: -1}},
+	{Operation: OperationPinChatMessage, Config17: tgbotapi.PinChatMessageConfig{ChatID: -1, MessageID: 2, DisableNotification: true}},
+	{Operation: OperationUnpinChatMessage, Config18: tgbotapi.UnpinChatMessageConfig{ChatID: -1}},
+	{Operation: OperationSetChatTitle, Config19: tgbotapi.SetChatTitleConfig{ChatID: -1, Title: "title"}},
+	{Operation: OperationSetChatDescription, Config20: tgbotapi.SetChatDescriptionConfig{ChatID: -1, Description: "description"}},
+	{Operation: OperationSetChatPhoto, Config21: tgbotapi.SetChatPhotoConfig{BaseFile: tgbotapi.BaseFile{BaseChat: tgbotapi.BaseChat{ChatID: -1}, File: "photo.jpg"}}},
+	{Operation: OperationDeleteChatPhoto, Config22: tgbotapi.DeleteChatPhotoConfig{ChatID: -1}},
+	{Operation: OperationGetCapabilities},
+}
+
+// testResults has the result of every operation returning something else
+// than a tgbotapi.APIResponse, by ResponseMessage field.
+var testResults = map[string]interface{}{
+	"R":   tgbotapi.APIResponse{Ok: true, Result: json.RawMessage(`true`)},
+	"R3":  "https://example.com/file",
+	"R4":  tgbotapi.User{ID: 1, UserName: "bot", IsBot: true},
+	"R5":  true,
+	"R6":  tgbotapi.Message{MessageID: 1, Text: "text", Chat: &tgbotapi.Chat{ID: 1}},
+	"R7":  tgbotapi.UserProfilePhotos{TotalCount: 1, Photos: [][]tgbotapi.PhotoSize{{{FileID: "photo"}}}},
+	"R8":  tgbotapi.File{FileID: "file", FilePath: "path"},
+	"R9":  []tgbotapi.Update{{UpdateID: 1}, {UpdateID: 2}},
+	"R10": tgbotapi.WebhookInfo{URL: "https://example.com/hook", PendingUpdateCount: 1},
+	"R12": tgbotapi.Chat{ID: -1, Type: "group"},
+	"R13": []tgbotapi.ChatMember{{User: &tgbotapi.User{ID: 1}, Status: "administrator"}},
+	"R14": 3,
+	"R15": tgbotapi.ChatMember{User: &tgbotapi.User{ID: 1}, Status: "member"},
+	"R16": []tgbotapi.GameHighScore{{Position: 1, Score: 2}},
+	"R17": Capabilities{ProtocolVersion: ProtocolVersion, Operations: []string{OperationSend}},
+}
+
+// requestPayload returns the RequestMessage fields holding the arguments of
+// operation.
+func requestPayload(operation string) []string {
+	switch operation {
+	case OperationMakeRequest:
+		return []string{"Endpoint", "Params"}
+	case OperationUploadFile:
+		return []string{"Endpoint", "Params2", "Fieldname", "File"}
+	case OperationSend:
+		return []string{"C"}
+	}
+
+	if name, ok := requestFields[operation]; ok {
+		return []string{name}
+	}
+
+	return nil
+}
+
+// responsePayload returns the ResponseMessage field holding the result of
+// operation, if any.
+func responsePayload(operation string) string {
+	name, ok := responseFields[operation]
+	if !ok {
+		return "R"
+	}
+
+	return name
+}
+
+func TestTestRequestsCoverOperations(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, n := range testRequests {
+		covered[n.Operation] = true
+	}
+
+	for _, operation := range operations {
+		if !covered[operation] {
+			t.Errorf("no test request for %s", operation)
+		}
+
+		if name := responsePayload(operation); name != "" && testResults[name] == nil {
+			t.Errorf("no test result for %s in %s", operation, name)
+		}
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	for _, format := range protocolFormats {
+		for _, n := range testRequests {
+			n.CorrelationId = "correlation-id"
+			n.Bot = "bot"
+			n.IdempotencyKey = "idempotency-key"
+
+			t.Run(format.name+"/"+n.Operation, func(t *testing.T) {
+				body, err := EncodeRequest(format.codec, &n, format.version)
+				if err != nil {
+					t.Fatalf("EncodeRequest() error = %v", err)
+				}
+
+				got, version, err := DecodeRequest(format.codec, body)
+				if err != nil {
+					t.Fatalf("DecodeRequest() error = %v", err)
+				}
+
+				if version != format.version {
+					t.Errorf("DecodeRequest() version = %d, want %d", version, format.version)
+				}
+
+				fields := append([]string{"Operation", "CorrelationId", "Bot", "IdempotencyKey"}, requestPayload(n.Operation)...)
+				for _, name := range fields {
+					gotField := reflect.ValueOf(got).FieldByName(name).Interface()
+					wantField := reflect.ValueOf(n).FieldByName(name).Interface()
+					if !reflect.DeepEqual(gotField, wantField) {
+						t.Errorf("DecodeRequest() %s = %#v, want %#v", name, gotField, wantField)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	errorResults := []struct {
+		name string
+		err  error
+	}{
+		{"ok", nil},
+		{"internal error", errors.New("internal")},
+		{"telegram error", tgbotapi.Error{Message: "Bad Request: chat not found"}},
+		{"flood wait", tgbotapi.Error{
+			Message:            "Too Many Requests: retry after 5",
+			ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5},
+		}},
+	}
+
+	for _, format := range protocolFormats {
+		for _, operation := range operations {
+			for _, errorResult := range errorResults {
+				r := ResponseMessage{
+					Operation:     operation,
+					CorrelationId: "correlation-id",
+					R2:            NewConcreteError(errorResult.err),
+				}
+
+				name := responsePayload(operation)
+				if name != "" && errorResult.err == nil {
+					reflect.ValueOf(&r).Elem().FieldByName(name).Set(reflect.ValueOf(testResults[name]))
+				}
+
+				t.Run(format.name+"/"+operation+"/"+errorResult.name, func(t *testing.T) {
+					body, err := EncodeResponse(format.codec, &r, format.version)
+					if err != nil {
+						t.Fatalf("EncodeResponse() error = %v", err)
+					}
+
+					got, version, err := DecodeResponse(format.codec, body)
+					if err != nil {
+						t.Fatalf("DecodeResponse() error = %v", err)
+					}
+
+					if version != format.version {
+						t.Errorf("DecodeResponse() version = %d, want %d", version, format.version)
+					}
+
+					fields := []string{"Operation", "CorrelationId", "R2"}
+					if name != "" && errorResult.err == nil {
+						fields = append(fields, name)
+					}
+
+					for _, name := range fields {
+						gotField := reflect.ValueOf(got).FieldByName(name).Interface()
+						wantField := reflect.ValueOf(r).FieldByName(name).Interface()
+						if !reflect.DeepEqual(gotField, wantField) {
+							t.Errorf("DecodeResponse() %s = %#v, want %#v", name, gotField, wantField)
+						}
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package rbot
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is a number of calls allowed per period, at most Count of them in a
+// burst. The zero Rate is unlimited.
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+var (
+	DefaultGlobalRate = Rate{30, time.Second}
+	DefaultChatRate   = Rate{1, time.Second}
+	DefaultGroupRate  = Rate{20, time.Minute}
+)
+
+// bucket is a token bucket kept as the time its next token is theoretically
+// available, so that calls can reserve a token ahead and wait for it.
+type bucket struct {
+	next time.Time
+}
+
+// available returns when a token of b is available at rate.
+func (b *bucket) available(rate Rate, now time.Time) time.Time {
+	if rate.Count <= 0 || rate.Per <= 0 {
+		return now
+	}
+
+	// Count tokens are available in a burst once the bucket refilled.
+	burst := time.Duration(rate.Count-1) * rate.Per / time.Duration(rate.Count)
+	return b.next.Add(-burst)
+}
+
+// take consumes a token of b at the time at.
+func (b *bucket) take(rate Rate, at time.Time) {
+	if rate.Count <= 0 || rate.Per <= 0 {
+		return
+	}
+
+	if b.next.Before(at) {
+		b.next = at
+	}
+	b.next = b.next.Add(rate.Per / time.Duration(rate.Count))
+}
+
+// rateLimiter schedules the sending calls of one bot within a global rate
+// and a rate per chat, the one of groups and channels being different.
+type rateLimiter struct {
+	global Rate
+	chat   Rate
+	group  Rate
+
+	mutex     sync.Mutex
+	all       bucket
+	chats     map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(global Rate, chat Rate, group Rate) *rateLimiter {
+	return &rateLimiter{
+		global: global,
+		chat:   chat,
+		group:  group,
+		chats:  make(map[string]*bucket),
+	}
+}
+
+// reserve reserves the slot of a call to chat, possibly empty, and returns
+// when the call is allowed. Calls reserve their slot when they arrive, so they
+// are let through in order. It returns false, reserving nothing, if the slot
+// is after deadline unless it is the zero time.
+func (l *rateLimiter) reserve(chat string, deadline time.Time) (time.Time, bool) {
+	now := time.Now()
+
+	l.mutex.Lock()
+
+	at := now
+	if t := l.all.available(l.global, now); t.After(at) {
+		at = t
+	}
+
+	var b *bucket
+	rate := l.chat
+	if chat != "" {
+		// Groups and channels have negative IDs, or are named by username.
+		if strings.HasPrefix(chat, "-") || strings.HasPrefix(chat, "@") {
+			rate = l.group
+		}
+
+		var ok bool
+		b, ok = l.chats[chat]
+		if !ok {
+			b = &bucket{}
+			l.chats[chat] = b
+		}
+
+		if t := b.available(rate, now); t.After(at) {
+			at = t
+		}
+	}
+
+	if !deadline.IsZero() && at.After(deadline) {
+		l.mutex.Unlock()
+		return at, false
+	}
+
+	l.all.take(l.global, at)
+	if b != nil {
+		b.take(rate, at)
+	}
+
+	l.sweep(now)
+	l.mutex.Unlock()
+
+	return at, true
+}
+
+// sweep forgets, at most once a minute, the chats whose bucket refilled.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for chat, b := range l.chats {
+		if b.next.Before(now) {
+			delete(l.chats, chat)
+		}
+	}
+}
+
+// sendingChat reports whether n sends to Telegram chats, and which chat, so
+// it is subject to rate limiting.
+func sendingChat(n *RequestMessage) (string, bool) {
+	switch n.Operation {
+	case OperationSend:
+		return chatKey(n), true
+	case OperationUploadFile:
+		return n.Params2["chat_id"], true
+	}
+
+	return "", false
+}
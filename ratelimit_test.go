@@ -0,0 +1,64 @@
+package rbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Date(2018, 11, 7, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		rate  Rate
+		takes int
+		want  time.Time
+	}{
+		{"unlimited", Rate{}, 100, now},
+		{"within burst", Rate{3, 3 * time.Second}, 2, now},
+		{"burst", Rate{3, 3 * time.Second}, 3, now.Add(time.Second)},
+		{"one per second", Rate{1, time.Second}, 1, now.Add(time.Second)},
+		{"one per second twice", Rate{1, time.Second}, 2, now.Add(2 * time.Second)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := bucket{}
+			for i := 0; i < test.takes; i++ {
+				at := now
+				if next := b.available(test.rate, now); next.After(at) {
+					at = next
+				}
+				b.take(test.rate, at)
+			}
+
+			got := b.available(test.rate, now)
+			if !got.Equal(test.want) {
+				t.Errorf("available() = %v, want %v", got.Sub(now), test.want.Sub(now))
+			}
+		})
+	}
+}
+
+func TestRateLimiterReserveDeadline(t *testing.T) {
+	l := newRateLimiter(Rate{}, Rate{1, time.Hour}, Rate{})
+
+	at, ok := l.reserve("1", time.Now().Add(time.Minute))
+	if !ok || at.After(time.Now()) {
+		t.Fatalf("first reserve() = %v, %t, want now, true", at, ok)
+	}
+
+	next := l.chats["1"].next
+	if _, ok := l.reserve("1", time.Now().Add(time.Minute)); ok {
+		t.Fatal("reserve() past the deadline = true, want false")
+	}
+
+	if !l.chats["1"].next.Equal(next) {
+		t.Error("reserve() past the deadline reserved a slot")
+	}
+
+	at, ok = l.reserve("1", time.Time{})
+	if !ok || !at.Equal(next) {
+		t.Errorf("reserve() without deadline = %v, %t, want %v, true", at, ok, next)
+	}
+}
@@ -1,8 +1,9 @@
 package rbot
 
 import (
-	"encoding/json"
+	"context"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/go-telegram-bot-api/telegram-bot-api"
@@ -10,20 +11,31 @@ import (
 )
 
 const (
-	RandomStringLength = 32
-	RoutingKey         = "tgbotapi"
-	DefaultTimeout     = 15 * time.Second
+	RandomStringLength  = 32
+	RoutingKey          = "tgbotapi"
+	RequestExchangeKind = "direct"
+	DirectReplyTo       = "amq.rabbitmq.reply-to"
+	DefaultTimeout      = 15 * time.Second
 )
 
 const (
 	FailedConnect             = "failed to connect to RabbitMQ"
+	FailedConnectionLost      = "connection to RabbitMQ lost"
 	FailedConvertBodyRequest  = "failed to convert body to request"
 	FailedConvertBodyResponse = "failed to convert body to response"
 	FailedDeclareQueue        = "failed to declare a queue"
+	FailedDeclareExchange     = "failed to declare an exchange"
+	FailedBindQueue           = "failed to bind a queue"
 	FailedOpenChannel         = "failed to open a channel"
 	FailedMessagePublish      = "failed to publish a message"
 	FailedMessageConsume      = "failed to register a consumer"
+	FailedMessageConfirm      = "failed to confirm a message"
 	FailedOptionQoS           = "failed to set QoS"
+	FailedOptionConfirm       = "failed to set confirm mode"
+	FailedShutdown            = "failed to shut down gracefully"
+	FailedCompatibility       = "server is not compatible"
+	FailedDialOption          = "invalid dial options"
+	FailedServeWebhooks       = "failed to serve webhooks"
 )
 
 const (
@@ -63,6 +75,7 @@ const (
 	OperationSetChatDescription     = "SetChatDescription"
 	OperationSetChatPhoto           = "SetChatPhoto"
 	OperationDeleteChatPhoto        = "DeleteChatPhoto"
+	OperationGetCapabilities        = "GetCapabilities"
 )
 
 type BotAPIIface interface {
@@ -104,42 +117,137 @@ type BotAPIIface interface {
 	DeleteChatPhoto(config tgbotapi.DeleteChatPhotoConfig) (tgbotapi.APIResponse, error)
 }
 
+// BotAPIContextIface mirrors BotAPIIface, taking a context.Context that
+// cancels the call or sets its deadline.
+type BotAPIContextIface interface {
+	MakeRequestContext(ctx context.Context, endpoint string, params url.Values) (tgbotapi.APIResponse, error)
+	UploadFileContext(ctx context.Context, endpoint string, params map[string]string, fieldname string, file interface{}) (tgbotapi.APIResponse, error)
+	GetFileDirectURLContext(ctx context.Context, fileID string) (string, error)
+	GetMeContext(ctx context.Context) (tgbotapi.User, error)
+	IsMessageToMeContext(ctx context.Context, message tgbotapi.Message) bool
+	SendContext(ctx context.Context, c tgbotapi.Chattable) (tgbotapi.Message, error)
+	GetUserProfilePhotosContext(ctx context.Context, config tgbotapi.UserProfilePhotosConfig) (tgbotapi.UserProfilePhotos, error)
+	GetFileContext(ctx context.Context, config tgbotapi.FileConfig) (tgbotapi.File, error)
+	GetUpdatesContext(ctx context.Context, config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error)
+	RemoveWebhookContext(ctx context.Context) (tgbotapi.APIResponse, error)
+	SetWebhookContext(ctx context.Context, config tgbotapi.WebhookConfig) (tgbotapi.APIResponse, error)
+	GetWebhookInfoContext(ctx context.Context) (tgbotapi.WebhookInfo, error)
+	GetUpdatesChanContext(ctx context.Context, config tgbotapi.UpdateConfig) (tgbotapi.UpdatesChannel, error)
+	ListenForWebhookContext(ctx context.Context, pattern string) tgbotapi.UpdatesChannel
+	AnswerInlineQueryContext(ctx context.Context, config tgbotapi.InlineConfig) (tgbotapi.APIResponse, error)
+	AnswerCallbackQueryContext(ctx context.Context, config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error)
+	KickChatMemberContext(ctx context.Context, config tgbotapi.KickChatMemberConfig) (tgbotapi.APIResponse, error)
+	LeaveChatContext(ctx context.Context, config tgbotapi.ChatConfig) (tgbotapi.APIResponse, error)
+	GetChatContext(ctx context.Context, config tgbotapi.ChatConfig) (tgbotapi.Chat, error)
+	GetChatAdministratorsContext(ctx context.Context, config tgbotapi.ChatConfig) ([]tgbotapi.ChatMember, error)
+	GetChatMembersCountContext(ctx context.Context, config tgbotapi.ChatConfig) (int, error)
+	GetChatMemberContext(ctx context.Context, config tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error)
+	UnbanChatMemberContext(ctx context.Context, config tgbotapi.ChatMemberConfig) (tgbotapi.APIResponse, error)
+	RestrictChatMemberContext(ctx context.Context, config tgbotapi.RestrictChatMemberConfig) (tgbotapi.APIResponse, error)
+	PromoteChatMemberContext(ctx context.Context, config tgbotapi.PromoteChatMemberConfig) (tgbotapi.APIResponse, error)
+	GetGameHighScoresContext(ctx context.Context, config tgbotapi.GetGameHighScoresConfig) ([]tgbotapi.GameHighScore, error)
+	AnswerShippingQueryContext(ctx context.Context, config tgbotapi.ShippingConfig) (tgbotapi.APIResponse, error)
+	AnswerPreCheckoutQueryContext(ctx context.Context, config tgbotapi.PreCheckoutConfig) (tgbotapi.APIResponse, error)
+	DeleteMessageContext(ctx context.Context, config tgbotapi.DeleteMessageConfig) (tgbotapi.APIResponse, error)
+	GetInviteLinkContext(ctx context.Context, config tgbotapi.ChatConfig) (string, error)
+	PinChatMessageContext(ctx context.Context, config tgbotapi.PinChatMessageConfig) (tgbotapi.APIResponse, error)
+	UnpinChatMessageContext(ctx context.Context, config tgbotapi.UnpinChatMessageConfig) (tgbotapi.APIResponse, error)
+	SetChatTitleContext(ctx context.Context, config tgbotapi.SetChatTitleConfig) (tgbotapi.APIResponse, error)
+	SetChatDescriptionContext(ctx context.Context, config tgbotapi.SetChatDescriptionConfig) (tgbotapi.APIResponse, error)
+	SetChatPhotoContext(ctx context.Context, config tgbotapi.SetChatPhotoConfig) (tgbotapi.APIResponse, error)
+	DeleteChatPhotoContext(ctx context.Context, config tgbotapi.DeleteChatPhotoConfig) (tgbotapi.APIResponse, error)
+}
+
 var _ BotAPIIface = (*tgbotapi.BotAPI)(nil)
 var _ BotAPIIface = (*RemoteBotAPI)(nil)
+var _ BotAPIContextIface = (*RemoteBotAPI)(nil)
 
-func RemoteBotDial(url string) (*RemoteBotAPI, error) {
-	conn, err := amqp.Dial(url)
+func RemoteBotDial(url string, options ...DialOption) (*RemoteBotAPI, error) {
+	rbot := new(RemoteBotAPI)
+	rbot.url = url
+	rbot.options = newDialOptions(options)
+
+	err := rbot.options.validate()
 	if err != nil {
 		return nil, err
 	}
 
-	rbot := new(RemoteBotAPI)
-	rbot.Connection = conn
 	rbot.Timeout = DefaultTimeout
+	rbot.Buffer = DefaultBuffer
+	rbot.ready = make(chan struct{})
+	rbot.closed = make(chan struct{})
+	rbot.shutdownChannel = make(chan interface{})
+
+	s, err := rbot.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	go rbot.reconnect(s)
+
+	if rbot.options.checkCompatibility {
+		err = rbot.checkCompatibility(rbot.options.requiredFeatures)
+		if err != nil {
+			RemoteBotClose(rbot)
+			return nil, err
+		}
+	}
 
 	return rbot, nil
 }
 
 func RemoteBotClose(rbot *RemoteBotAPI) {
+	rbot.sessionMutex.Lock()
+	defer rbot.sessionMutex.Unlock()
+
+	select {
+	case <-rbot.closed:
+		return
+	default:
+		close(rbot.closed)
+	}
+
+	if rbot.session != nil {
+		rbot.session.channel.Close()
+	}
 	rbot.Connection.Close()
 }
 
 type RemoteBotAPI struct {
+	// Connection is the current connection to the broker, it is replaced
+	// when the client reconnects.
 	Connection *amqp.Connection
 	Timeout    time.Duration
+	Buffer     int
+
+	url     string
+	options dialOptions
+
+	// session is nil while the client is reconnecting, ready is closed as
+	// soon as a new session is available.
+	sessionMutex sync.Mutex
+	session      *session
+	ready        chan struct{}
+	closed       chan struct{}
+
+	shutdownChannel chan interface{}
+}
+
+// StopReceivingUpdates stops every updates channel returned by
+// GetUpdatesChan, closing them.
+func (rbot *RemoteBotAPI) StopReceivingUpdates() {
+	close(rbot.shutdownChannel)
 }
 
 func (rbot *RemoteBotAPI) MakeRequest(endpoint string, params url.Values) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.MakeRequestContext(ctx, endpoint, params)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) MakeRequestContext(ctx context.Context, endpoint string, params url.Values) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationMakeRequest,
@@ -148,7 +256,7 @@ func (rbot *RemoteBotAPI) MakeRequest(endpoint string, params url.Values) (tgbot
 		Params:        params,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -157,16 +265,14 @@ func (rbot *RemoteBotAPI) MakeRequest(endpoint string, params url.Values) (tgbot
 }
 
 func (rbot *RemoteBotAPI) UploadFile(endpoint string, params2 map[string]string, fieldname string, file interface{}) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.UploadFileContext(ctx, endpoint, params2, fieldname, file)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) UploadFileContext(ctx context.Context, endpoint string, params2 map[string]string, fieldname string, file interface{}) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationUploadFile,
@@ -177,7 +283,7 @@ func (rbot *RemoteBotAPI) UploadFile(endpoint string, params2 map[string]string,
 		File:          file,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -186,16 +292,14 @@ func (rbot *RemoteBotAPI) UploadFile(endpoint string, params2 map[string]string,
 }
 
 func (rbot *RemoteBotAPI) GetFileDirectURL(fileID string) (string, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result string
+	return rbot.GetFileDirectURLContext(ctx, fileID)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetFileDirectURLContext(ctx context.Context, fileID string) (string, error) {
+	var result string
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetFileDirectURL,
@@ -203,7 +307,7 @@ func (rbot *RemoteBotAPI) GetFileDirectURL(fileID string) (string, error) {
 		FileID:        fileID,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -212,23 +316,21 @@ func (rbot *RemoteBotAPI) GetFileDirectURL(fileID string) (string, error) {
 }
 
 func (rbot *RemoteBotAPI) GetMe() (tgbotapi.User, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.User
+	return rbot.GetMeContext(ctx)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetMeContext(ctx context.Context) (tgbotapi.User, error) {
+	var result tgbotapi.User
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetMe,
 		CorrelationId: randomString(RandomStringLength),
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -237,71 +339,36 @@ func (rbot *RemoteBotAPI) GetMe() (tgbotapi.User, error) {
 }
 
 func (rbot *RemoteBotAPI) IsMessageToMe(message tgbotapi.Message) bool {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
-
-	ch, err := rbot.Connection.Channel()
-	if err != nil {
-		//return result, NewErrorRemoteBot(FailedOpenChannel, err)
-		return false
-	}
-	defer ch.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	q, err := CreateQueue(ch)
-	if err != nil {
-		//return result, NewErrorRemoteBot(FailedDeclareQueue, err)
-		return false
-	}
-
-	msgs, err := CreateConsumeChannel(ch, q.Name)
-	if err != nil {
-		//return result, NewErrorRemoteBot(FailedMessageConsume, err)
-		return false
-	}
+	return rbot.IsMessageToMeContext(ctx, message)
+}
 
+func (rbot *RemoteBotAPI) IsMessageToMeContext(ctx context.Context, message tgbotapi.Message) bool {
 	requestMessage := RequestMessage{
 		Operation:     OperationIsMessageToMe,
 		CorrelationId: randomString(RandomStringLength),
 		Message:       message,
 	}
 
-	request, err := json.Marshal(requestMessage)
-	if err != nil {
-		panic(err)
-	}
-
-	err = Publish(ch, &requestMessage, q.Name, request)
-	if err != nil {
-		//return result, NewErrorRemoteBot(FailedMessagePublish, err)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
+	if remoteBotErr != nil {
 		return false
 	}
 
-	var response ResponseMessage
-	for d := range msgs {
-		if requestMessage.CorrelationId == d.CorrelationId {
-			err := json.Unmarshal(d.Body, &response)
-			if err != nil {
-				//return result, NewErrorRemoteBot(FailedConvertBodyResponse, err)
-				return false
-			}
-			break
-		}
-	}
-
 	return response.R5
 }
 
 func (rbot *RemoteBotAPI) Send(c tgbotapi.Chattable) (result tgbotapi.Message, err error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	cC := NewConcreteChattable(c)
+	return rbot.SendContext(ctx, c)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) SendContext(ctx context.Context, c tgbotapi.Chattable) (result tgbotapi.Message, err error) {
+	cC := NewConcreteChattable(c)
 
 	requestMessage := RequestMessage{
 		Operation:     OperationSend,
@@ -309,7 +376,7 @@ func (rbot *RemoteBotAPI) Send(c tgbotapi.Chattable) (result tgbotapi.Message, e
 		C:             cC,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -318,16 +385,14 @@ func (rbot *RemoteBotAPI) Send(c tgbotapi.Chattable) (result tgbotapi.Message, e
 }
 
 func (rbot *RemoteBotAPI) GetUserProfilePhotos(config tgbotapi.UserProfilePhotosConfig) (tgbotapi.UserProfilePhotos, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.UserProfilePhotos
+	return rbot.GetUserProfilePhotosContext(ctx, config)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetUserProfilePhotosContext(ctx context.Context, config tgbotapi.UserProfilePhotosConfig) (tgbotapi.UserProfilePhotos, error) {
+	var result tgbotapi.UserProfilePhotos
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetUserProfilePhotos,
@@ -335,7 +400,7 @@ func (rbot *RemoteBotAPI) GetUserProfilePhotos(config tgbotapi.UserProfilePhotos
 		Config:        config,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -344,16 +409,14 @@ func (rbot *RemoteBotAPI) GetUserProfilePhotos(config tgbotapi.UserProfilePhotos
 }
 
 func (rbot *RemoteBotAPI) GetFile(config2 tgbotapi.FileConfig) (tgbotapi.File, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.File
+	return rbot.GetFileContext(ctx, config2)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetFileContext(ctx context.Context, config2 tgbotapi.FileConfig) (tgbotapi.File, error) {
+	var result tgbotapi.File
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetFile,
@@ -361,7 +424,7 @@ func (rbot *RemoteBotAPI) GetFile(config2 tgbotapi.FileConfig) (tgbotapi.File, e
 		Config2:       config2,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -370,16 +433,14 @@ func (rbot *RemoteBotAPI) GetFile(config2 tgbotapi.FileConfig) (tgbotapi.File, e
 }
 
 func (rbot *RemoteBotAPI) GetUpdates(config3 tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result []tgbotapi.Update
+	return rbot.GetUpdatesContext(ctx, config3)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetUpdatesContext(ctx context.Context, config3 tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	var result []tgbotapi.Update
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetUpdates,
@@ -387,7 +448,7 @@ func (rbot *RemoteBotAPI) GetUpdates(config3 tgbotapi.UpdateConfig) ([]tgbotapi.
 		Config3:       config3,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -396,23 +457,21 @@ func (rbot *RemoteBotAPI) GetUpdates(config3 tgbotapi.UpdateConfig) ([]tgbotapi.
 }
 
 func (rbot *RemoteBotAPI) RemoveWebhook() (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.RemoveWebhookContext(ctx)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) RemoveWebhookContext(ctx context.Context) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationRemoveWebhook,
 		CorrelationId: randomString(RandomStringLength),
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -421,16 +480,14 @@ func (rbot *RemoteBotAPI) RemoveWebhook() (tgbotapi.APIResponse, error) {
 }
 
 func (rbot *RemoteBotAPI) SetWebhook(config4 tgbotapi.WebhookConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.SetWebhookContext(ctx, config4)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) SetWebhookContext(ctx context.Context, config4 tgbotapi.WebhookConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationSetWebhook,
@@ -438,7 +495,7 @@ func (rbot *RemoteBotAPI) SetWebhook(config4 tgbotapi.WebhookConfig) (tgbotapi.A
 		Config4:       config4,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -447,23 +504,21 @@ func (rbot *RemoteBotAPI) SetWebhook(config4 tgbotapi.WebhookConfig) (tgbotapi.A
 }
 
 func (rbot *RemoteBotAPI) GetWebhookInfo() (tgbotapi.WebhookInfo, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.WebhookInfo
+	return rbot.GetWebhookInfoContext(ctx)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetWebhookInfoContext(ctx context.Context) (tgbotapi.WebhookInfo, error) {
+	var result tgbotapi.WebhookInfo
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetWebhookInfo,
 		CorrelationId: randomString(RandomStringLength),
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -472,83 +527,75 @@ func (rbot *RemoteBotAPI) GetWebhookInfo() (tgbotapi.WebhookInfo, error) {
 }
 
 func (rbot *RemoteBotAPI) GetUpdatesChan(config3 tgbotapi.UpdateConfig) (tgbotapi.UpdatesChannel, error) {
-	// TODO(tinti) not implemented
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
+	return rbot.GetUpdatesChanContext(ctx, config3)
+}
+
+func (rbot *RemoteBotAPI) GetUpdatesChanContext(ctx context.Context, config3 tgbotapi.UpdateConfig) (tgbotapi.UpdatesChannel, error) {
 	var result tgbotapi.UpdatesChannel
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
+	subscription := updatesSubscription{
+		exchange: BotExchangeName(rbot.options.updatesExchange, rbot.options.bot),
+		kind:     UpdatesExchangeKind,
+		requestMessage: RequestMessage{
+			Operation: OperationGetUpdatesChan,
+			Config3:   config3,
+		},
 	}
-	defer ch.Close()
 
-	requestMessage := RequestMessage{
-		Operation:     OperationGetUpdatesChan,
-		CorrelationId: randomString(RandomStringLength),
-		Config3:       config3,
+	ch, msgs, err := rbot.subscribe(ctx, &subscription)
+	if err != nil {
+		return result, err
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
+	updates := make(chan tgbotapi.Update, rbot.Buffer)
+	go rbot.forwardUpdates(&subscription, ch, msgs, updates)
 
-	result = make(tgbotapi.UpdatesChannel)
-	return result, response.R2.ToError()
+	return updates, nil
 }
 
 func (rbot *RemoteBotAPI) ListenForWebhook(pattern string) tgbotapi.UpdatesChannel {
-	// TODO(tinti) not implemented
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
+
+	return rbot.ListenForWebhookContext(ctx, pattern)
+}
 
+func (rbot *RemoteBotAPI) ListenForWebhookContext(ctx context.Context, pattern string) tgbotapi.UpdatesChannel {
 	var result tgbotapi.UpdatesChannel
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result
+	subscription := updatesSubscription{
+		exchange: BotExchangeName(rbot.options.webhookExchange, rbot.options.bot),
+		kind:     WebhookExchangeKind,
+		key:      pattern,
+		requestMessage: RequestMessage{
+			Operation: OperationListenForWebhook,
+			Pattern:   pattern,
+		},
 	}
-	defer ch.Close()
 
-	requestMessage := RequestMessage{
-		Operation:     OperationListenForWebhook,
-		CorrelationId: randomString(RandomStringLength),
-		Pattern:       pattern,
-	}
-	request, err := json.Marshal(requestMessage)
+	ch, msgs, err := rbot.subscribe(ctx, &subscription)
 	if err != nil {
-		panic(err)
-	}
-
-	remoteBotErr = PublishWithTimeout(ch, &requestMessage, q.Name, request, ticker)
-	if remoteBotErr != nil {
 		return result
 	}
 
-	response, remoteBotErr := ConsumeWithTimeout(requestMessage.CorrelationId, msgs, ticker)
-	if remoteBotErr != nil {
-		return result
-	}
+	updates := make(chan tgbotapi.Update, rbot.Buffer)
+	go rbot.forwardUpdates(&subscription, ch, msgs, updates)
 
-	// TODO(tinti) remove this
-	// This is just for refactoring
-	_ = response
-	return result
+	return updates
 }
 
 func (rbot *RemoteBotAPI) AnswerInlineQuery(config5 tgbotapi.InlineConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.AnswerInlineQueryContext(ctx, config5)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) AnswerInlineQueryContext(ctx context.Context, config5 tgbotapi.InlineConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationAnswerInlineQuery,
@@ -556,7 +603,7 @@ func (rbot *RemoteBotAPI) AnswerInlineQuery(config5 tgbotapi.InlineConfig) (tgbo
 		Config5:       config5,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -565,16 +612,14 @@ func (rbot *RemoteBotAPI) AnswerInlineQuery(config5 tgbotapi.InlineConfig) (tgbo
 }
 
 func (rbot *RemoteBotAPI) AnswerCallbackQuery(config6 tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.AnswerCallbackQueryContext(ctx, config6)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) AnswerCallbackQueryContext(ctx context.Context, config6 tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationAnswerCallbackQuery,
@@ -582,7 +627,7 @@ func (rbot *RemoteBotAPI) AnswerCallbackQuery(config6 tgbotapi.CallbackConfig) (
 		Config6:       config6,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -591,16 +636,14 @@ func (rbot *RemoteBotAPI) AnswerCallbackQuery(config6 tgbotapi.CallbackConfig) (
 }
 
 func (rbot *RemoteBotAPI) KickChatMember(config7 tgbotapi.KickChatMemberConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.KickChatMemberContext(ctx, config7)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) KickChatMemberContext(ctx context.Context, config7 tgbotapi.KickChatMemberConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationKickChatMember,
@@ -608,7 +651,7 @@ func (rbot *RemoteBotAPI) KickChatMember(config7 tgbotapi.KickChatMemberConfig)
 		Config7:       config7,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -617,16 +660,14 @@ func (rbot *RemoteBotAPI) KickChatMember(config7 tgbotapi.KickChatMemberConfig)
 }
 
 func (rbot *RemoteBotAPI) LeaveChat(config8 tgbotapi.ChatConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.LeaveChatContext(ctx, config8)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) LeaveChatContext(ctx context.Context, config8 tgbotapi.ChatConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationLeaveChat,
@@ -634,7 +675,7 @@ func (rbot *RemoteBotAPI) LeaveChat(config8 tgbotapi.ChatConfig) (tgbotapi.APIRe
 		Config8:       config8,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -643,16 +684,14 @@ func (rbot *RemoteBotAPI) LeaveChat(config8 tgbotapi.ChatConfig) (tgbotapi.APIRe
 }
 
 func (rbot *RemoteBotAPI) GetChat(config8 tgbotapi.ChatConfig) (tgbotapi.Chat, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.Chat
+	return rbot.GetChatContext(ctx, config8)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetChatContext(ctx context.Context, config8 tgbotapi.ChatConfig) (tgbotapi.Chat, error) {
+	var result tgbotapi.Chat
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetChat,
@@ -660,7 +699,7 @@ func (rbot *RemoteBotAPI) GetChat(config8 tgbotapi.ChatConfig) (tgbotapi.Chat, e
 		Config8:       config8,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -669,16 +708,14 @@ func (rbot *RemoteBotAPI) GetChat(config8 tgbotapi.ChatConfig) (tgbotapi.Chat, e
 }
 
 func (rbot *RemoteBotAPI) GetChatAdministrators(config8 tgbotapi.ChatConfig) ([]tgbotapi.ChatMember, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result []tgbotapi.ChatMember
+	return rbot.GetChatAdministratorsContext(ctx, config8)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetChatAdministratorsContext(ctx context.Context, config8 tgbotapi.ChatConfig) ([]tgbotapi.ChatMember, error) {
+	var result []tgbotapi.ChatMember
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetChatAdministrators,
@@ -686,7 +723,7 @@ func (rbot *RemoteBotAPI) GetChatAdministrators(config8 tgbotapi.ChatConfig) ([]
 		Config8:       config8,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -695,16 +732,14 @@ func (rbot *RemoteBotAPI) GetChatAdministrators(config8 tgbotapi.ChatConfig) ([]
 }
 
 func (rbot *RemoteBotAPI) GetChatMembersCount(config8 tgbotapi.ChatConfig) (int, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result int
+	return rbot.GetChatMembersCountContext(ctx, config8)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetChatMembersCountContext(ctx context.Context, config8 tgbotapi.ChatConfig) (int, error) {
+	var result int
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetChatMembersCount,
@@ -712,7 +747,7 @@ func (rbot *RemoteBotAPI) GetChatMembersCount(config8 tgbotapi.ChatConfig) (int,
 		Config8:       config8,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -721,16 +756,14 @@ func (rbot *RemoteBotAPI) GetChatMembersCount(config8 tgbotapi.ChatConfig) (int,
 }
 
 func (rbot *RemoteBotAPI) GetChatMember(config9 tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.ChatMember
+	return rbot.GetChatMemberContext(ctx, config9)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetChatMemberContext(ctx context.Context, config9 tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error) {
+	var result tgbotapi.ChatMember
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetChatMember,
@@ -738,7 +771,7 @@ func (rbot *RemoteBotAPI) GetChatMember(config9 tgbotapi.ChatConfigWithUser) (tg
 		Config9:       config9,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -747,16 +780,14 @@ func (rbot *RemoteBotAPI) GetChatMember(config9 tgbotapi.ChatConfigWithUser) (tg
 }
 
 func (rbot *RemoteBotAPI) UnbanChatMember(config10 tgbotapi.ChatMemberConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.UnbanChatMemberContext(ctx, config10)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) UnbanChatMemberContext(ctx context.Context, config10 tgbotapi.ChatMemberConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationUnbanChatMember,
@@ -764,7 +795,7 @@ func (rbot *RemoteBotAPI) UnbanChatMember(config10 tgbotapi.ChatMemberConfig) (t
 		Config10:      config10,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -773,16 +804,14 @@ func (rbot *RemoteBotAPI) UnbanChatMember(config10 tgbotapi.ChatMemberConfig) (t
 }
 
 func (rbot *RemoteBotAPI) RestrictChatMember(config11 tgbotapi.RestrictChatMemberConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.RestrictChatMemberContext(ctx, config11)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) RestrictChatMemberContext(ctx context.Context, config11 tgbotapi.RestrictChatMemberConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationRestrictChatMember,
@@ -790,7 +819,7 @@ func (rbot *RemoteBotAPI) RestrictChatMember(config11 tgbotapi.RestrictChatMembe
 		Config11:      config11,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -799,16 +828,14 @@ func (rbot *RemoteBotAPI) RestrictChatMember(config11 tgbotapi.RestrictChatMembe
 }
 
 func (rbot *RemoteBotAPI) PromoteChatMember(config12 tgbotapi.PromoteChatMemberConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.PromoteChatMemberContext(ctx, config12)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) PromoteChatMemberContext(ctx context.Context, config12 tgbotapi.PromoteChatMemberConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationPromoteChatMember,
@@ -816,7 +843,7 @@ func (rbot *RemoteBotAPI) PromoteChatMember(config12 tgbotapi.PromoteChatMemberC
 		Config12:      config12,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -825,16 +852,14 @@ func (rbot *RemoteBotAPI) PromoteChatMember(config12 tgbotapi.PromoteChatMemberC
 }
 
 func (rbot *RemoteBotAPI) GetGameHighScores(config13 tgbotapi.GetGameHighScoresConfig) ([]tgbotapi.GameHighScore, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result []tgbotapi.GameHighScore
+	return rbot.GetGameHighScoresContext(ctx, config13)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetGameHighScoresContext(ctx context.Context, config13 tgbotapi.GetGameHighScoresConfig) ([]tgbotapi.GameHighScore, error) {
+	var result []tgbotapi.GameHighScore
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetGameHighScores,
@@ -842,7 +867,7 @@ func (rbot *RemoteBotAPI) GetGameHighScores(config13 tgbotapi.GetGameHighScoresC
 		Config13:      config13,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -851,16 +876,14 @@ func (rbot *RemoteBotAPI) GetGameHighScores(config13 tgbotapi.GetGameHighScoresC
 }
 
 func (rbot *RemoteBotAPI) AnswerShippingQuery(config14 tgbotapi.ShippingConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.AnswerShippingQueryContext(ctx, config14)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) AnswerShippingQueryContext(ctx context.Context, config14 tgbotapi.ShippingConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationAnswerShippingQuery,
@@ -868,7 +891,7 @@ func (rbot *RemoteBotAPI) AnswerShippingQuery(config14 tgbotapi.ShippingConfig)
 		Config14:      config14,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -877,16 +900,14 @@ func (rbot *RemoteBotAPI) AnswerShippingQuery(config14 tgbotapi.ShippingConfig)
 }
 
 func (rbot *RemoteBotAPI) AnswerPreCheckoutQuery(config15 tgbotapi.PreCheckoutConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.AnswerPreCheckoutQueryContext(ctx, config15)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) AnswerPreCheckoutQueryContext(ctx context.Context, config15 tgbotapi.PreCheckoutConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationAnswerPreCheckoutQuery,
@@ -894,7 +915,7 @@ func (rbot *RemoteBotAPI) AnswerPreCheckoutQuery(config15 tgbotapi.PreCheckoutCo
 		Config15:      config15,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -903,16 +924,14 @@ func (rbot *RemoteBotAPI) AnswerPreCheckoutQuery(config15 tgbotapi.PreCheckoutCo
 }
 
 func (rbot *RemoteBotAPI) DeleteMessage(config16 tgbotapi.DeleteMessageConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.DeleteMessageContext(ctx, config16)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) DeleteMessageContext(ctx context.Context, config16 tgbotapi.DeleteMessageConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationDeleteMessage,
@@ -920,7 +939,7 @@ func (rbot *RemoteBotAPI) DeleteMessage(config16 tgbotapi.DeleteMessageConfig) (
 		Config16:      config16,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -929,16 +948,14 @@ func (rbot *RemoteBotAPI) DeleteMessage(config16 tgbotapi.DeleteMessageConfig) (
 }
 
 func (rbot *RemoteBotAPI) GetInviteLink(config8 tgbotapi.ChatConfig) (string, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result string
+	return rbot.GetInviteLinkContext(ctx, config8)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) GetInviteLinkContext(ctx context.Context, config8 tgbotapi.ChatConfig) (string, error) {
+	var result string
 
 	requestMessage := RequestMessage{
 		Operation:     OperationGetInviteLink,
@@ -946,7 +963,7 @@ func (rbot *RemoteBotAPI) GetInviteLink(config8 tgbotapi.ChatConfig) (string, er
 		Config8:       config8,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -955,16 +972,14 @@ func (rbot *RemoteBotAPI) GetInviteLink(config8 tgbotapi.ChatConfig) (string, er
 }
 
 func (rbot *RemoteBotAPI) PinChatMessage(config17 tgbotapi.PinChatMessageConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.PinChatMessageContext(ctx, config17)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) PinChatMessageContext(ctx context.Context, config17 tgbotapi.PinChatMessageConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationPinChatMessage,
@@ -972,7 +987,7 @@ func (rbot *RemoteBotAPI) PinChatMessage(config17 tgbotapi.PinChatMessageConfig)
 		Config17:      config17,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -981,16 +996,14 @@ func (rbot *RemoteBotAPI) PinChatMessage(config17 tgbotapi.PinChatMessageConfig)
 }
 
 func (rbot *RemoteBotAPI) UnpinChatMessage(config18 tgbotapi.UnpinChatMessageConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.UnpinChatMessageContext(ctx, config18)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) UnpinChatMessageContext(ctx context.Context, config18 tgbotapi.UnpinChatMessageConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationUnpinChatMessage,
@@ -998,7 +1011,7 @@ func (rbot *RemoteBotAPI) UnpinChatMessage(config18 tgbotapi.UnpinChatMessageCon
 		Config18:      config18,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -1007,16 +1020,14 @@ func (rbot *RemoteBotAPI) UnpinChatMessage(config18 tgbotapi.UnpinChatMessageCon
 }
 
 func (rbot *RemoteBotAPI) SetChatTitle(config19 tgbotapi.SetChatTitleConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.SetChatTitleContext(ctx, config19)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) SetChatTitleContext(ctx context.Context, config19 tgbotapi.SetChatTitleConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationSetChatTitle,
@@ -1024,7 +1035,7 @@ func (rbot *RemoteBotAPI) SetChatTitle(config19 tgbotapi.SetChatTitleConfig) (tg
 		Config19:      config19,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -1033,16 +1044,14 @@ func (rbot *RemoteBotAPI) SetChatTitle(config19 tgbotapi.SetChatTitleConfig) (tg
 }
 
 func (rbot *RemoteBotAPI) SetChatDescription(config20 tgbotapi.SetChatDescriptionConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.SetChatDescriptionContext(ctx, config20)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) SetChatDescriptionContext(ctx context.Context, config20 tgbotapi.SetChatDescriptionConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationSetChatDescription,
@@ -1050,7 +1059,7 @@ func (rbot *RemoteBotAPI) SetChatDescription(config20 tgbotapi.SetChatDescriptio
 		Config20:      config20,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -1059,16 +1068,14 @@ func (rbot *RemoteBotAPI) SetChatDescription(config20 tgbotapi.SetChatDescriptio
 }
 
 func (rbot *RemoteBotAPI) SetChatPhoto(config21 tgbotapi.SetChatPhotoConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.SetChatPhotoContext(ctx, config21)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) SetChatPhotoContext(ctx context.Context, config21 tgbotapi.SetChatPhotoConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationSetChatPhoto,
@@ -1076,7 +1083,7 @@ func (rbot *RemoteBotAPI) SetChatPhoto(config21 tgbotapi.SetChatPhotoConfig) (tg
 		Config21:      config21,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
@@ -1085,16 +1092,14 @@ func (rbot *RemoteBotAPI) SetChatPhoto(config21 tgbotapi.SetChatPhotoConfig) (tg
 }
 
 func (rbot *RemoteBotAPI) DeleteChatPhoto(config22 tgbotapi.DeleteChatPhotoConfig) (tgbotapi.APIResponse, error) {
-	ticker := time.NewTicker(rbot.Timeout)
-	defer ticker.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+	defer cancel()
 
-	var result tgbotapi.APIResponse
+	return rbot.DeleteChatPhotoContext(ctx, config22)
+}
 
-	ch, q, msgs, remoteBotErr := CreateRpcBase(rbot.Connection)
-	if remoteBotErr != nil {
-		return result, remoteBotErr
-	}
-	defer ch.Close()
+func (rbot *RemoteBotAPI) DeleteChatPhotoContext(ctx context.Context, config22 tgbotapi.DeleteChatPhotoConfig) (tgbotapi.APIResponse, error) {
+	var result tgbotapi.APIResponse
 
 	requestMessage := RequestMessage{
 		Operation:     OperationDeleteChatPhoto,
@@ -1102,7 +1107,7 @@ func (rbot *RemoteBotAPI) DeleteChatPhoto(config22 tgbotapi.DeleteChatPhotoConfi
 		Config22:      config22,
 	}
 
-	response, remoteBotErr := RpcWithTimeout(ch, q, msgs, &requestMessage, ticker)
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
 	if remoteBotErr != nil {
 		return result, remoteBotErr
 	}
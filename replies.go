@@ -0,0 +1,415 @@
+package rbot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	ReconnectMinDelay = 1 * time.Second
+	ReconnectMaxDelay = 30 * time.Second
+	ConfirmsBuffer    = 64
+
+	// HeaderDeadline holds the time, in RFC 3339 format, after which the
+	// client no longer waits for the reply of a request.
+	HeaderDeadline = "x-rbot-deadline"
+)
+
+// session is the channel and reply queue shared by every call made over one
+// AMQP connection. Replies are routed to the waiting callers by
+// CorrelationId.
+type session struct {
+	connection *amqp.Connection
+	channel    *amqp.Channel
+	replyQueue amqp.Queue
+
+	mutex   sync.Mutex
+	pending map[string]chan amqp.Delivery
+
+	// confirms holds the calls waiting for the broker to confirm their
+	// publishing, by delivery tag. It is nil unless the channel is in
+	// confirm mode.
+	confirmsMutex sync.Mutex
+	confirms      map[uint64]*pendingConfirm
+	deliveryTag   uint64
+}
+
+// dispatchReplies routes every delivery of the shared reply queue to the call
+// waiting for its CorrelationId. Replies nobody waits for anymore, e.g. the
+// call already timed out, are dropped.
+func (s *session) dispatchReplies(msgs <-chan amqp.Delivery) {
+	for d := range msgs {
+		s.mutex.Lock()
+		replies, ok := s.pending[d.CorrelationId]
+		if ok {
+			select {
+			case replies <- d:
+			default:
+			}
+		}
+		s.mutex.Unlock()
+	}
+
+	// The consumer is gone, wake up every waiting call. They fail instead of
+	// being retried because their requests may already have been executed.
+	s.mutex.Lock()
+	for correlationId, replies := range s.pending {
+		close(replies)
+		delete(s.pending, correlationId)
+	}
+	s.mutex.Unlock()
+}
+
+// pendingConfirm is a publishing waiting for its confirm, result receives nil
+// once the broker accepted it.
+type pendingConfirm struct {
+	correlationId string
+	result        chan error
+}
+
+// dispatchConfirms hands every publisher confirm to the call waiting for it,
+// failing the ones whose message the broker returned as unroutable.
+func (s *session) dispatchConfirms(confirmations <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	returned := make(map[string]string)
+
+	markReturned := func(r amqp.Return, ok bool) {
+		if !ok {
+			returns = nil
+			return
+		}
+		returned[r.CorrelationId] = r.ReplyText
+	}
+
+	for confirmations != nil {
+		select {
+		case r, ok := <-returns:
+			markReturned(r, ok)
+		case c, ok := <-confirmations:
+			if !ok {
+				confirmations = nil
+				break
+			}
+
+			// The broker returns a message before confirming it, the return
+			// is already buffered.
+		drain:
+			for {
+				select {
+				case r, ok := <-returns:
+					markReturned(r, ok)
+				default:
+					break drain
+				}
+			}
+
+			s.confirmsMutex.Lock()
+			pending, ok := s.confirms[c.DeliveryTag]
+			if ok {
+				delete(s.confirms, c.DeliveryTag)
+			}
+			s.confirmsMutex.Unlock()
+
+			if !ok {
+				continue
+			}
+
+			replyText, isReturned := returned[pending.correlationId]
+			delete(returned, pending.correlationId)
+
+			switch {
+			case isReturned:
+				pending.result <- NewErrorRemoteBot(FailedMessageConfirm, fmt.Errorf("returned by the broker: %s", replyText))
+			case !c.Ack:
+				pending.result <- NewErrorRemoteBot(FailedMessageConfirm, fmt.Errorf("nacked by the broker"))
+			default:
+				pending.result <- nil
+			}
+		}
+	}
+
+	s.confirmsMutex.Lock()
+	for deliveryTag, pending := range s.confirms {
+		close(pending.result)
+		delete(s.confirms, deliveryTag)
+	}
+	s.confirmsMutex.Unlock()
+}
+
+// publish sends msg and, if the channel is in confirm mode, waits for the
+// broker to confirm it routed the message to a queue and accepted it.
+func (s *session) publish(ctx context.Context, exchange string, key string, msg amqp.Publishing) error {
+	if s.confirms == nil {
+		return publishWithContext(ctx, func() error {
+			return s.channel.Publish(exchange, key, false, false, msg)
+		})
+	}
+
+	pending := &pendingConfirm{
+		correlationId: msg.CorrelationId,
+		result:        make(chan error, 1),
+	}
+
+	remoteBotErr := publishWithContext(ctx, func() error {
+		// Delivery tags are assigned in publishing order, the waiter is
+		// registered before dispatchConfirms can look for it.
+		s.confirmsMutex.Lock()
+		defer s.confirmsMutex.Unlock()
+
+		// Mandatory makes the broker return the message instead of dropping
+		// it when no queue is bound to key.
+		err := s.channel.Publish(exchange, key, true, false, msg)
+		if err != nil {
+			return err
+		}
+
+		s.deliveryTag++
+		s.confirms[s.deliveryTag] = pending
+
+		return nil
+	})
+	if remoteBotErr != nil {
+		return remoteBotErr
+	}
+
+	select {
+	case err, ok := <-pending.result:
+		if !ok {
+			return NewErrorRemoteBot(FailedConnectionLost, nil)
+		}
+
+		return err
+	case <-ctx.Done():
+		return NewErrorRemoteBot(FailedMessageConfirm, ctx.Err())
+	}
+}
+
+func (s *session) register(correlationId string) <-chan amqp.Delivery {
+	replies := make(chan amqp.Delivery, 1)
+
+	s.mutex.Lock()
+	s.pending[correlationId] = replies
+	s.mutex.Unlock()
+
+	return replies
+}
+
+func (s *session) unregister(correlationId string) {
+	s.mutex.Lock()
+	replies, ok := s.pending[correlationId]
+	if ok {
+		close(replies)
+		delete(s.pending, correlationId)
+	}
+	s.mutex.Unlock()
+}
+
+// connect dials the broker and sets up a new session, making it the one used
+// by the following calls.
+func (rbot *RemoteBotAPI) connect() (*session, error) {
+	conn, err := amqp.Dial(rbot.url)
+	if err != nil {
+		return nil, NewErrorRemoteBot(FailedConnect, err)
+	}
+
+	createRpcBase := CreateRpcBase
+	if rbot.options.directReplyTo {
+		createRpcBase = CreateDirectReplyToRpcBase
+	}
+
+	ch, q, msgs, remoteBotErr := createRpcBase(conn)
+	if remoteBotErr != nil {
+		conn.Close()
+		return nil, remoteBotErr
+	}
+
+	s := &session{
+		connection: conn,
+		channel:    ch,
+		replyQueue: q,
+		pending:    make(map[string]chan amqp.Delivery),
+	}
+
+	if rbot.options.reliable {
+		err = ch.Confirm(false)
+		if err != nil {
+			conn.Close()
+			return nil, NewErrorRemoteBot(FailedOptionConfirm, err)
+		}
+
+		s.confirms = make(map[uint64]*pendingConfirm)
+		go s.dispatchConfirms(
+			ch.NotifyPublish(make(chan amqp.Confirmation, ConfirmsBuffer)),
+			ch.NotifyReturn(make(chan amqp.Return, ConfirmsBuffer)),
+		)
+	}
+
+	go s.dispatchReplies(msgs)
+
+	rbot.sessionMutex.Lock()
+	defer rbot.sessionMutex.Unlock()
+
+	select {
+	case <-rbot.closed:
+		conn.Close()
+		return nil, NewErrorRemoteBot(FailedConnect, fmt.Errorf("closed"))
+	default:
+	}
+
+	rbot.Connection = conn
+	rbot.session = s
+	close(rbot.ready)
+
+	return s, nil
+}
+
+// reconnect watches the connection and the channel of s, and dials again
+// with an exponential backoff every time either is closed, until
+// RemoteBotClose is called. Errors are passed to the error handler.
+func (rbot *RemoteBotAPI) reconnect(s *session) {
+	for {
+		notifyClose := s.connection.NotifyClose(make(chan *amqp.Error, 1))
+		notifyChannelClose := s.channel.NotifyClose(make(chan *amqp.Error, 1))
+
+		var reason *amqp.Error
+		select {
+		case <-rbot.closed:
+			return
+		case reason = <-notifyClose:
+		case reason = <-notifyChannelClose:
+			// A channel exception, e.g. publishing to an exchange not
+			// declared yet, leaves the connection up. Start over with a new
+			// one so the session is set up again.
+			s.connection.Close()
+		}
+
+		select {
+		case <-rbot.closed:
+			return
+		default:
+		}
+
+		if reason != nil {
+			rbot.options.errorHandler(NewErrorRemoteBot(FailedConnectionLost, reason))
+		}
+
+		// Calls made from now on wait for the next session.
+		rbot.sessionMutex.Lock()
+		rbot.session = nil
+		rbot.ready = make(chan struct{})
+		rbot.sessionMutex.Unlock()
+
+		delay := ReconnectMinDelay
+		for {
+			select {
+			case <-rbot.closed:
+				return
+			case <-time.After(delay):
+			}
+
+			var err error
+			s, err = rbot.connect()
+			if err == nil {
+				break
+			}
+			rbot.options.errorHandler(err)
+
+			delay *= 2
+			if delay > ReconnectMaxDelay {
+				delay = ReconnectMaxDelay
+			}
+		}
+	}
+}
+
+// currentSession returns the session calls must use, waiting for the client
+// to reconnect if the connection was lost.
+func (rbot *RemoteBotAPI) currentSession(ctx context.Context) (*session, error) {
+	for {
+		rbot.sessionMutex.Lock()
+		s, ready := rbot.session, rbot.ready
+		rbot.sessionMutex.Unlock()
+
+		if s != nil {
+			return s, nil
+		}
+
+		select {
+		case <-ready:
+		case <-rbot.closed:
+			return nil, NewErrorRemoteBot(FailedConnect, fmt.Errorf("closed"))
+		case <-ctx.Done():
+			return nil, NewErrorRemoteBot(FailedConnect, ctx.Err())
+		}
+	}
+}
+
+func (rbot *RemoteBotAPI) rpcWithContext(ctx context.Context, requestMessage *RequestMessage) (*ResponseMessage, error) {
+	s, remoteBotErr := rbot.currentSession(ctx)
+	if remoteBotErr != nil {
+		return nil, remoteBotErr
+	}
+
+	replies := s.register(requestMessage.CorrelationId)
+	defer s.unregister(requestMessage.CorrelationId)
+
+	remoteBotErr = rbot.publishRequest(ctx, s, requestMessage, s.replyQueue.Name)
+	if remoteBotErr != nil {
+		return nil, remoteBotErr
+	}
+
+	return ConsumeWithContext(ctx, requestMessage.CorrelationId, replies)
+}
+
+// notifyWithContext publishes a request without a reply queue, the server
+// executes it without replying.
+func (rbot *RemoteBotAPI) notifyWithContext(ctx context.Context, requestMessage *RequestMessage) error {
+	s, remoteBotErr := rbot.currentSession(ctx)
+	if remoteBotErr != nil {
+		return remoteBotErr
+	}
+
+	return rbot.publishRequest(ctx, s, requestMessage, "")
+}
+
+func (rbot *RemoteBotAPI) publishRequest(ctx context.Context, s *session, requestMessage *RequestMessage, replyTo string) error {
+	requestMessage.Bot = rbot.options.bot
+	requestMessage.IdempotencyKey = idempotencyKey(ctx)
+
+	request, err := EncodeRequest(rbot.options.codec, requestMessage, rbot.options.protocolVersion)
+	if err != nil {
+		return NewErrorRemoteBot(FailedConvertBodyRequest, err)
+	}
+
+	request, encoding := compress(request, rbot.options.compressionThreshold)
+
+	msg := amqp.Publishing{
+		Headers:         amqp.Table{HeaderAcceptEncoding: EncodingGzip},
+		ContentType:     rbot.options.codec.ContentType(),
+		ContentEncoding: encoding,
+		CorrelationId:   requestMessage.CorrelationId,
+		ReplyTo:         replyTo,
+		Priority:        priority(ctx, requestMessage.Operation),
+		Body:            request,
+	}
+	if rbot.options.reliable {
+		msg.DeliveryMode = amqp.Persistent
+	}
+
+	// The server skips requests the client stopped waiting for.
+	if deadline, ok := ctx.Deadline(); ok {
+		expiration := time.Until(deadline) / time.Millisecond
+		if expiration < 1 {
+			expiration = 1
+		}
+
+		msg.Expiration = strconv.FormatInt(int64(expiration), 10)
+		msg.Headers[HeaderDeadline] = deadline.UTC().Format(time.RFC3339Nano)
+	}
+
+	return s.publish(ctx, rbot.options.exchange, rbot.options.requestKey(), msg)
+}
@@ -183,8 +183,10 @@ func (c *ConcreteChattable) ToChattable() tgbotapi.Chattable {
 }
 
 type RequestMessage struct {
-	Operation     string
-	CorrelationId string
+	Operation      string
+	CorrelationId  string
+	Bot            string
+	IdempotencyKey string
 
 	C         ConcreteChattable
 	Config    tgbotapi.UserProfilePhotosConfig
@@ -1,28 +1,135 @@
 package rbot
 
 import (
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
+// Kinds of remote errors: the server failed to reach Telegram, Telegram
+// rejected the call, or the server failed on its own.
+const (
+	ErrorKindTransport = "transport"
+	ErrorKindTelegram  = "telegram"
+	ErrorKindInternal  = "internal"
+)
+
+// retryAfterPattern matches the flood-wait errors tgbotapi returns as plain
+// strings, e.g. from UploadFile.
+var retryAfterPattern = regexp.MustCompile(`retry after (\d+)`)
+
+// telegramCodes are the HTTP status codes of the Telegram errors, by the
+// prefix of their description, as tgbotapi drops the code.
+var telegramCodes = map[string]int{
+	"Bad Request":       400,
+	"Unauthorized":      401,
+	"Forbidden":         403,
+	"Not Found":         404,
+	"Conflict":          409,
+	"Too Many Requests": 429,
+}
+
+// ConcreteError is an error sent over the wire. Value is its message, the
+// other fields describe it for the servers and clients that know them, Code,
+// Description and Parameters being the ones of Telegram errors. RetryAfter
+// is Parameters.RetryAfter, kept for older clients.
 type ConcreteError struct {
-	IsNil bool
-	Value string
+	IsNil      bool
+	Value      string
+	RetryAfter int
+
+	Kind        string
+	Code        int
+	Description string
+	Parameters  tgbotapi.ResponseParameters
 }
 
+// ToError rebuilds the error: an ErrorTelegram, or an ErrorFloodWait wrapping
+// it, for Telegram errors and an ErrorRemote for the other kinds. Errors of
+// servers predating kinds are ErrorString.
 func (e *ConcreteError) ToError() error {
 	if e.IsNil {
 		return nil
 	}
 
+	switch e.Kind {
+	case ErrorKindTelegram:
+		telegramErr := &ErrorTelegram{e.Code, e.Description, e.Parameters}
+		if e.Parameters.RetryAfter > 0 {
+			return &ErrorFloodWait{e.Value, time.Duration(e.Parameters.RetryAfter) * time.Second, telegramErr}
+		}
+
+		return telegramErr
+	case ErrorKindTransport, ErrorKindInternal:
+		return &ErrorRemote{e.Kind, e.Value}
+	}
+
+	if e.RetryAfter > 0 {
+		return &ErrorFloodWait{e.Value, time.Duration(e.RetryAfter) * time.Second, nil}
+	}
+
 	return &ErrorString{e.Value}
 }
 
 func NewConcreteError(e error) ConcreteError {
-	if e != nil {
-		return ConcreteError{false, e.Error()}
+	if e == nil {
+		return ConcreteError{IsNil: true}
+	}
+
+	c := ConcreteError{
+		Value:       e.Error(),
+		Kind:        ErrorKindInternal,
+		Description: e.Error(),
+	}
+
+	var telegramErr tgbotapi.Error
+	var netErr net.Error
+
+	switch {
+	case errors.As(e, &telegramErr):
+		c.Kind = ErrorKindTelegram
+		c.Description = telegramErr.Message
+		c.Parameters = telegramErr.ResponseParameters
+	case errors.As(e, &netErr):
+		c.Kind = ErrorKindTransport
+	case telegramCode(c.Description) != 0:
+		// Some tgbotapi calls return Telegram errors as plain strings.
+		c.Kind = ErrorKindTelegram
+	}
+
+	if c.Kind == ErrorKindTelegram {
+		c.Code = telegramCode(c.Description)
+		if c.Parameters.RetryAfter == 0 {
+			c.Parameters.RetryAfter = retryAfter(c.Description)
+		}
+		c.RetryAfter = c.Parameters.RetryAfter
+	}
+
+	return c
+}
+
+func telegramCode(description string) int {
+	prefix := description
+	if i := strings.Index(description, ":"); i >= 0 {
+		prefix = description[:i]
+	}
+
+	return telegramCodes[prefix]
+}
+
+func retryAfter(description string) int {
+	m := retryAfterPattern.FindStringSubmatch(description)
+	if m == nil {
+		return 0
 	}
 
-	return ConcreteError{true, ""}
+	seconds, _ := strconv.Atoi(m[1])
+	return seconds
 }
 
 type ResponseMessage struct {
@@ -45,4 +152,5 @@ type ResponseMessage struct {
 	R14 int
 	R15 tgbotapi.ChatMember
 	R16 []tgbotapi.GameHighScore
+	R17 Capabilities
 }
@@ -0,0 +1,149 @@
+package rbot
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestConcreteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		kind       string
+		code       int
+		retryAfter int
+		wantType   string
+		telegram   bool
+
+		// message is the one of the rebuilt error, if not the one of err.
+		message string
+	}{
+		{
+			name:     "telegram",
+			err:      tgbotapi.Error{Message: "Bad Request: chat not found"},
+			kind:     ErrorKindTelegram,
+			code:     400,
+			wantType: "*rbot.ErrorTelegram",
+			telegram: true,
+		},
+		{
+			name:     "wrapped telegram",
+			err:      fmt.Errorf("send: %w", tgbotapi.Error{Message: "Forbidden: bot was blocked by the user"}),
+			kind:     ErrorKindTelegram,
+			code:     403,
+			wantType: "*rbot.ErrorTelegram",
+			telegram: true,
+			message:  "Forbidden: bot was blocked by the user",
+		},
+		{
+			name: "telegram flood wait",
+			err: tgbotapi.Error{
+				Message:            "Too Many Requests: retry after 5",
+				ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5},
+			},
+			kind:       ErrorKindTelegram,
+			code:       429,
+			retryAfter: 5,
+			wantType:   "*rbot.ErrorFloodWait",
+			telegram:   true,
+		},
+		{
+			name:       "plain string flood wait",
+			err:        errors.New("Too Many Requests: retry after 7"),
+			kind:       ErrorKindTelegram,
+			code:       429,
+			retryAfter: 7,
+			wantType:   "*rbot.ErrorFloodWait",
+			telegram:   true,
+		},
+		{
+			name:     "plain string telegram",
+			err:      errors.New("Unauthorized"),
+			kind:     ErrorKindTelegram,
+			code:     401,
+			wantType: "*rbot.ErrorTelegram",
+			telegram: true,
+		},
+		{
+			name:     "transport",
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			kind:     ErrorKindTransport,
+			wantType: "*rbot.ErrorRemote",
+		},
+		{
+			name:     "internal",
+			err:      errors.New("unknown bot"),
+			kind:     ErrorKindInternal,
+			wantType: "*rbot.ErrorRemote",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewConcreteError(test.err)
+			if c.IsNil || c.Kind != test.kind || c.Code != test.code || c.RetryAfter != test.retryAfter {
+				t.Errorf("NewConcreteError() = %+v, want kind %q, code %d, retry after %d", c, test.kind, test.code, test.retryAfter)
+			}
+
+			err := c.ToError()
+			if got := fmt.Sprintf("%T", err); got != test.wantType {
+				t.Fatalf("ToError() = %s, want %s", got, test.wantType)
+			}
+
+			message := test.message
+			if message == "" {
+				message = test.err.Error()
+			}
+
+			if err.Error() != message {
+				t.Errorf("ToError().Error() = %q, want %q", err.Error(), message)
+			}
+
+			var telegramErr tgbotapi.Error
+			if errors.As(err, &telegramErr) != test.telegram {
+				t.Errorf("errors.As(tgbotapi.Error) = %t, want %t", !test.telegram, test.telegram)
+			}
+
+			var floodWait *ErrorFloodWait
+			if errors.As(err, &floodWait) && floodWait.RetryAfter != time.Duration(test.retryAfter)*time.Second {
+				t.Errorf("ErrorFloodWait.RetryAfter = %s, want %ds", floodWait.RetryAfter, test.retryAfter)
+			}
+
+			var remoteErr *ErrorRemote
+			if errors.As(err, &remoteErr) && remoteErr.Kind != test.kind {
+				t.Errorf("ErrorRemote.Kind = %q, want %q", remoteErr.Kind, test.kind)
+			}
+		})
+	}
+}
+
+func TestConcreteErrorNil(t *testing.T) {
+	c := NewConcreteError(nil)
+	if !c.IsNil {
+		t.Errorf("NewConcreteError(nil).IsNil = false, want true")
+	}
+
+	if err := c.ToError(); err != nil {
+		t.Errorf("ToError() = %v, want nil", err)
+	}
+}
+
+// TestConcreteErrorLegacy covers the errors of servers predating kinds.
+func TestConcreteErrorLegacy(t *testing.T) {
+	err := (&ConcreteError{Value: "failed"}).ToError()
+	if _, ok := err.(*ErrorString); !ok {
+		t.Errorf("ToError() = %T, want *rbot.ErrorString", err)
+	}
+
+	err = (&ConcreteError{Value: "Too Many Requests: retry after 3", RetryAfter: 3}).ToError()
+
+	var floodWait *ErrorFloodWait
+	if !errors.As(err, &floodWait) || floodWait.RetryAfter != 3*time.Second || floodWait.Telegram != nil {
+		t.Errorf("ToError() = %#v, want an ErrorFloodWait of 3s without ErrorTelegram", err)
+	}
+}
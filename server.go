@@ -1,586 +1,1359 @@
 package rbot
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/streadway/amqp"
 )
 
+const (
+	DefaultWorkers         = 1
+	DefaultPrefetchCount   = 32
+	DefaultShutdownTimeout = 30 * time.Second
+	DefaultMaxFloodWait    = 30 * time.Second
+)
+
 func SimpleServerDefault(url string, bot *tgbotapi.BotAPI) {
 	SimpleServer(url, bot, func(error) {})
 }
 
 func SimpleServer(url string, bot *tgbotapi.BotAPI, errorHandler func(error)) error {
-	conn, err := amqp.Dial(url)
+	server := NewServer(url, bot)
+	server.ErrorHandler = errorHandler
+
+	return server.Run(context.Background())
+}
+
+// Server executes the requests of RemoteBotAPI clients against Bot, or
+// against one of Bots when they name one.
+type Server struct {
+	// expired is first to be 64-bit aligned for atomic operations.
+	expired uint64
+
+	URL          string
+	Bot          *tgbotapi.BotAPI
+	Bots         map[string]*tgbotapi.BotAPI
+	ErrorHandler func(error)
+
+	// QueueName is the queue requests are consumed from. When Exchange is
+	// set, the queue is bound to it with RoutingKey, or QueueName if empty.
+	// Clients must be dialed with the matching options.
+	QueueName  string
+	Exchange   string
+	RoutingKey string
+
+	// Durable declares the request queue and exchange as durable, so
+	// persistent requests survive a broker restart. An existing queue must
+	// be deleted before its durability can change.
+	Durable bool
+
+	// MaxPriority, if not zero, declares the request queue as a priority
+	// queue, delivering the requests with the highest priority first, see
+	// WithPriority. PriorityInteractive is the highest priority clients use
+	// by default. An existing queue must be deleted before it changes.
+	MaxPriority uint8
+
+	// DeadLetterExchange is where requests that cannot be decoded, or whose
+	// execution failed, are published to, see PublishDeadLetter.
+	// Without it they are dropped. DeadLetterQueue, if set, is declared and
+	// bound to it to keep them for InspectDeadLetters and RequeueDeadLetters.
+	DeadLetterExchange string
+	DeadLetterQueue    string
+
+	// UpdatesExchange and WebhookExchange are where the updates of
+	// GetUpdatesChan and ListenForWebhook are published.
+	UpdatesExchange string
+	WebhookExchange string
+
+	// Workers is how many requests are executed at once. PrefetchCount is
+	// how many unacknowledged deliveries the broker sends ahead, raised to
+	// Workers if lower so they are all kept busy. The requests queued behind
+	// their chat or delayed by the rate limits hold a delivery too, fewer
+	// deliveries stall the server sooner, more make priorities less strict.
+	Workers       int
+	PrefetchCount int
+
+	// ShutdownTimeout bounds how long Run waits, once its context is
+	// cancelled, for the requests being executed. Zero waits for them.
+	ShutdownTimeout time.Duration
+
+	// GlobalRate limits the sending calls, Send and UploadFile, of each bot.
+	// ChatRate and GroupRate limit them per private chat and per group or
+	// channel. Calls over the limits are delayed to their turn, holding their
+	// chat but not a worker, instead of failing. The zero Rate disables a
+	// limit.
+	GlobalRate Rate
+	ChatRate   Rate
+	GroupRate  Rate
+
+	// MaxFloodWait is the longest the server waits to retry a call Telegram
+	// rejected for flood control, bounded by the deadline of the call. Longer
+	// waits are returned to the client as an ErrorFloodWait. Zero disables
+	// retrying.
+	MaxFloodWait time.Duration
+
+	// IdempotencyStore keeps, for IdempotencyWindow, the successful
+	// responses of the requests made with WithIdempotencyKey. Retries with the
+	// same key get the stored response instead of being executed again.
+	// Duplicates running at the same time are only caught when they target
+	// the same chat, as they run one after the other. Nil disables it.
+	IdempotencyStore  IdempotencyStore
+	IdempotencyWindow time.Duration
+
+	// UpdatesCodec encodes the updates published to the updates and webhook
+	// exchanges, clients decode them according to their content type.
+	UpdatesCodec Codec
+
+	// CompressionThreshold is the size above which replies are gzipped, for
+	// the clients decoding them. Zero disables compression.
+	CompressionThreshold int
+
+	// WebhookMux is where the handlers of ListenForWebhook are registered.
+	// If WebhookAddr is set, Run serves it there until its context is
+	// cancelled, over TLS if WebhookCertFile and WebhookKeyFile are set.
+	// Otherwise the server process is expected to serve it.
+	WebhookMux      *http.ServeMux
+	WebhookAddr     string
+	WebhookCertFile string
+	WebhookKeyFile  string
+
+	consumer string
+
+	relaysMutex sync.Mutex
+	relays      map[string]*updatesRelay
+	webhooks    *webhooks
+	conn        *amqp.Connection
+
+	limitersMutex sync.Mutex
+	limiters      map[string]*rateLimiter
+}
+
+func NewServer(url string, bot *tgbotapi.BotAPI) *Server {
+	return &Server{
+		URL:                  url,
+		Bot:                  bot,
+		ErrorHandler:         func(error) {},
+		QueueName:            RoutingKey,
+		UpdatesExchange:      UpdatesExchange,
+		WebhookExchange:      WebhookExchange,
+		Workers:              DefaultWorkers,
+		PrefetchCount:        DefaultPrefetchCount,
+		ShutdownTimeout:      DefaultShutdownTimeout,
+		GlobalRate:           DefaultGlobalRate,
+		ChatRate:             DefaultChatRate,
+		GroupRate:            DefaultGroupRate,
+		MaxFloodWait:         DefaultMaxFloodWait,
+		IdempotencyStore:     NewMemoryIdempotencyStore(),
+		IdempotencyWindow:    DefaultIdempotencyWindow,
+		UpdatesCodec:         JSONCodec,
+		CompressionThreshold: DefaultCompressionThreshold,
+		WebhookMux:           http.NewServeMux(),
+	}
+}
+
+// NewMultiBotServer creates a Server for several bots, clients select one by
+// name with WithBot.
+func NewMultiBotServer(url string, bots map[string]*tgbotapi.BotAPI) *Server {
+	s := NewServer(url, nil)
+	s.Bots = bots
+
+	return s
+}
+
+// Run consumes and executes requests until ctx is cancelled, then shuts down
+// gracefully. Errors setting up the first connection are returned, later the
+// server reconnects with an exponential backoff, reporting errors to
+// ErrorHandler.
+func (s *Server) Run(ctx context.Context) error {
+	stopWebhooks, err := s.serveWebhooks()
+	if err != nil {
+		return err
+	}
+	defer stopWebhooks()
+
+	conn, ch, msgs, err := s.setup()
+	if err != nil {
+		return err
+	}
+
+	s.setConnection(conn)
+	defer s.closeRelays()
+
+	for {
+		err = s.serve(ctx, ch, msgs)
+		ch.Close()
+		conn.Close()
+
+		if err == nil {
+			return nil
+		}
+		s.ErrorHandler(err)
+
+		delay := ReconnectMinDelay
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+
+			conn, ch, msgs, err = s.setup()
+			if err == nil {
+				break
+			}
+			s.ErrorHandler(err)
+
+			delay *= 2
+			if delay > ReconnectMaxDelay {
+				delay = ReconnectMaxDelay
+			}
+		}
+
+		s.setConnection(conn)
+	}
+}
+
+// serveWebhooks serves WebhookMux on WebhookAddr, if set, until the returned
+// function is called. Listening errors are returned at once, the later ones
+// are reported to ErrorHandler.
+func (s *Server) serveWebhooks() (func(), error) {
+	if s.WebhookAddr == "" {
+		return func() {}, nil
+	}
+
+	listener, err := net.Listen("tcp", s.WebhookAddr)
 	if err != nil {
-		errorHandler(err)
+		return nil, NewErrorRemoteBot(FailedServeWebhooks, err)
+	}
+
+	server := &http.Server{Handler: s.WebhookMux}
+
+	go func() {
+		var err error
+		if s.WebhookCertFile != "" || s.WebhookKeyFile != "" {
+			err = server.ServeTLS(listener, s.WebhookCertFile, s.WebhookKeyFile)
+		} else {
+			err = server.Serve(listener)
+		}
+
+		if err != http.ErrServerClosed {
+			s.ErrorHandler(NewErrorRemoteBot(FailedServeWebhooks, err))
+		}
+	}()
+
+	return func() {
+		ctx := context.Background()
+		if s.ShutdownTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.ShutdownTimeout)
+			defer cancel()
+		}
+
+		err := server.Shutdown(ctx)
+		if err != nil {
+			s.ErrorHandler(NewErrorRemoteBot(FailedShutdown, err))
+		}
+	}, nil
+}
+
+// setConnection makes the updates relays publish over conn.
+func (s *Server) setConnection(conn *amqp.Connection) {
+	s.relaysMutex.Lock()
+	defer s.relaysMutex.Unlock()
+
+	s.conn = conn
+	for _, relay := range s.relays {
+		relay.setConnection(conn)
+	}
+}
+
+// relay returns the updates relay of the bot called name, creating it on
+// first use.
+func (s *Server) relay(name string, bot *tgbotapi.BotAPI) *updatesRelay {
+	s.relaysMutex.Lock()
+	defer s.relaysMutex.Unlock()
+
+	if s.relays == nil {
+		s.relays = make(map[string]*updatesRelay)
+	}
+
+	// Webhook patterns outlive the relays, as the mux keeps their handlers.
+	if s.webhooks == nil {
+		s.webhooks = newWebhooks(s.WebhookMux)
+	}
+
+	relay, ok := s.relays[name]
+	if !ok {
+		relay = newUpdatesRelay(s.conn, bot, s.ErrorHandler)
+		relay.name = name
+		relay.webhooks = s.webhooks
+		if s.UpdatesCodec != nil {
+			relay.codec = s.UpdatesCodec
+		}
+		relay.updatesExchange = BotExchangeName(s.UpdatesExchange, name)
+		relay.webhookExchange = BotExchangeName(s.WebhookExchange, name)
+		s.relays[name] = relay
+	}
+
+	return relay
+}
+
+// limiter returns the rate limiter of the bot called name, creating it on
+// first use. Limiters outlive reconnections.
+func (s *Server) limiter(name string) *rateLimiter {
+	s.limitersMutex.Lock()
+	defer s.limitersMutex.Unlock()
+
+	if s.limiters == nil {
+		s.limiters = make(map[string]*rateLimiter)
 	}
 
-	return NewErrorRemoteBot(FailedConnect, err)
-	defer conn.Close()
+	limiter, ok := s.limiters[name]
+	if !ok {
+		limiter = newRateLimiter(s.GlobalRate, s.ChatRate, s.GroupRate)
+		s.limiters[name] = limiter
+	}
+
+	return limiter
+}
+
+func (s *Server) closeRelays() {
+	s.relaysMutex.Lock()
+	defer s.relaysMutex.Unlock()
+
+	for _, relay := range s.relays {
+		relay.Close()
+	}
+	s.relays = nil
+
+	if s.webhooks != nil {
+		s.webhooks.detach()
+	}
+}
+
+// botName returns the name of the bot a request is for: the one it names,
+// else the routing key it was published with if that is a bot name, else the
+// empty name of the default Bot.
+func (s *Server) botName(n *RequestMessage, d *amqp.Delivery) string {
+	if n.Bot != "" {
+		return n.Bot
+	}
+
+	if _, ok := s.Bots[d.RoutingKey]; ok {
+		return d.RoutingKey
+	}
+
+	return ""
+}
+
+func (s *Server) lookupBot(name string) (*tgbotapi.BotAPI, error) {
+	if name == "" {
+		if s.Bot == nil {
+			return nil, fmt.Errorf("no default bot")
+		}
+
+		return s.Bot, nil
+	}
+
+	bot, ok := s.Bots[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown bot %q", name)
+	}
+
+	return bot, nil
+}
+
+// setup connects to the broker and starts consuming the request queue.
+func (s *Server) setup() (*amqp.Connection, *amqp.Channel, <-chan amqp.Delivery, error) {
+	conn, err := amqp.Dial(s.URL)
+	if err != nil {
+		return nil, nil, nil, NewErrorRemoteBot(FailedConnect, err)
+	}
 
 	ch, err := conn.Channel()
-	return NewErrorRemoteBot(FailedOpenChannel, err)
-	defer ch.Close()
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, NewErrorRemoteBot(FailedOpenChannel, err)
+	}
+
+	var args amqp.Table
+	if s.MaxPriority > 0 {
+		args = amqp.Table{"x-max-priority": int32(s.MaxPriority)}
+	}
 
 	q, err := ch.QueueDeclare(
-		RoutingKey, // name
-		false,      // durable
-		false,      // delete when usused
-		false,      // exclusive
-		false,      // no-wait
-		nil,        // arguments
+		s.QueueName, // name
+		s.Durable,   // durable
+		false,       // delete when usused
+		false,       // exclusive
+		false,       // no-wait
+		args,        // arguments
 	)
-	return NewErrorRemoteBot(FailedDeclareQueue, err)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, NewErrorRemoteBot(FailedDeclareQueue, err)
+	}
+
+	if s.Exchange != "" {
+		err = ch.ExchangeDeclare(
+			s.Exchange,          // name
+			RequestExchangeKind, // type
+			s.Durable,           // durable
+			false,               // auto-deleted
+			false,               // internal
+			false,               // no-wait
+			nil,                 // arguments
+		)
+		if err != nil {
+			conn.Close()
+			return nil, nil, nil, NewErrorRemoteBot(FailedDeclareExchange, err)
+		}
+
+		key := s.RoutingKey
+		if key == "" {
+			key = q.Name
+		}
+
+		// Requests can also select a bot by its name as routing key.
+		keys := []string{key}
+		for name := range s.Bots {
+			keys = append(keys, name)
+		}
+
+		for _, key := range keys {
+			err = ch.QueueBind(
+				q.Name,     // queue name
+				key,        // routing key
+				s.Exchange, // exchange
+				false,      // no-wait
+				nil,        // arguments
+			)
+			if err != nil {
+				conn.Close()
+				return nil, nil, nil, NewErrorRemoteBot(FailedBindQueue, err)
+			}
+		}
+	}
+
+	if s.DeadLetterExchange != "" {
+		err = DeclareDeadLetterExchange(ch, s.DeadLetterExchange, s.DeadLetterQueue, s.Durable)
+		if err != nil {
+			conn.Close()
+			return nil, nil, nil, err
+		}
+	}
+
+	prefetchCount := s.PrefetchCount
+	if prefetchCount < s.Workers {
+		prefetchCount = s.Workers
+	}
 
 	err = ch.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
+		prefetchCount, // prefetch count
+		0,             // prefetch size
+		false,         // global
 	)
-	return NewErrorRemoteBot(FailedOptionQoS, err)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, NewErrorRemoteBot(FailedOptionQoS, err)
+	}
+
+	s.consumer = randomString(RandomStringLength)
 
 	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		false,  // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
+		q.Name,     // queue
+		s.consumer, // consumer
+		false,      // auto-ack
+		false,      // exclusive
+		false,      // no-local
+		false,      // no-wait
+		nil,        // args
 	)
-	return NewErrorRemoteBot(FailedMessageConsume, err)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, NewErrorRemoteBot(FailedMessageConsume, err)
+	}
 
-	forever := make(chan bool)
+	return conn, ch, msgs, nil
+}
 
-	go func() {
-		for d := range msgs {
-			var n RequestMessage
-			err = json.Unmarshal(d.Body, &n)
-			if err != nil {
-				errorHandler(err)
+// serve hands deliveries to the workers until ctx is cancelled, returning
+// nil, or until the connection is lost. Requests targeting the same chat run
+// one at a time, in delivery order. On shutdown it stops consuming, requeues
+// the deliveries no worker started and waits up to ShutdownTimeout for the
+// running ones to be replied and acknowledged.
+func (s *Server) serve(ctx context.Context, ch *amqp.Channel, msgs <-chan amqp.Delivery) error {
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	chats := newChatQueues()
+	delays := newDelayedJobs()
+	jobs := make(chan *job)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				for ; j != nil; j = chats.next(j) {
+					if at := s.handle(ch, j); !at.IsZero() {
+						delays.add(j, at)
+						break
+					}
+				}
+			}
+		}()
+	}
+
+	err := s.dispatch(ctx, msgs, chats, delays, jobs)
+	close(jobs)
+
+	// Once the connection is lost, unstarted deliveries are requeued by the
+	// broker anyway.
+	unstarted := append(chats.stop(), delays.stop()...)
+	if err == nil {
+		s.drain(ch, msgs, unstarted)
+	}
+	s.wait(&wg)
+
+	return err
+}
+
+func (s *Server) dispatch(ctx context.Context, msgs <-chan amqp.Delivery, chats *chatQueues, delays *delayedJobs, jobs chan<- *job) error {
+	for {
+		var j *job
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case j = <-delays.due:
+		case d, ok := <-msgs:
+			if !ok {
+				return NewErrorRemoteBot(FailedConnectionLost, nil)
 			}
 
-			var r ResponseMessage
-			switch n.Operation {
-			case OperationMakeRequest:
-				apiResponse, err := bot.MakeRequest(n.Endpoint, n.Params)
-
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
-
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationUploadFile:
-				apiResponse, err := bot.UploadFile(n.Endpoint, n.Params2, n.Fieldname, n.File)
-
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
-
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetFileDirectURL:
-				fileID, err := bot.GetFileDirectURL(n.FileID)
-
-				r = ResponseMessage{}
-				r.R3 = fileID
-				r.R2 = NewConcreteError(err)
-
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetMe:
-				user, err := bot.GetMe()
-
-				r = ResponseMessage{}
-				r.R4 = user
-				r.R2 = NewConcreteError(err)
-
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationIsMessageToMe:
-				yes := bot.IsMessageToMe(n.Message)
-
-				r = ResponseMessage{}
-				r.R5 = yes
-
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationSend:
-				switch n.C.Type {
-				case reflect.TypeOf(tgbotapi.MessageConfig{}).String():
-					m := n.C.ValueMessageConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.ForwardConfig{}).String():
-					m := n.C.ValueForwardConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.PhotoConfig{}).String():
-					m := n.C.ValuePhotoConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.AudioConfig{}).String():
-					m := n.C.ValueAudioConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.DocumentConfig{}).String():
-					m := n.C.ValueDocumentConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.StickerConfig{}).String():
-					m := n.C.ValueStickerConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.VideoConfig{}).String():
-					m := n.C.ValueVideoConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.AnimationConfig{}).String():
-					m := n.C.ValueAnimationConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.VideoNoteConfig{}).String():
-					m := n.C.ValueVideoNoteConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.VoiceConfig{}).String():
-					m := n.C.ValueVoiceConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.MediaGroupConfig{}).String():
-					m := n.C.ValueMediaGroupConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.LocationConfig{}).String():
-					m := n.C.ValueLocationConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.VenueConfig{}).String():
-					m := n.C.ValueVenueConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.ContactConfig{}).String():
-					m := n.C.ValueContactConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.GameConfig{}).String():
-					m := n.C.ValueGameConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.SetGameScoreConfig{}).String():
-					m := n.C.ValueSetGameScoreConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.GetGameHighScoresConfig{}).String():
-					m := n.C.ValueGetGameHighScoresConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.ChatActionConfig{}).String():
-					m := n.C.ValueChatActionConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.EditMessageTextConfig{}).String():
-					m := n.C.ValueEditMessageTextConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.EditMessageCaptionConfig{}).String():
-					m := n.C.ValueEditMessageCaptionConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.EditMessageReplyMarkupConfig{}).String():
-					m := n.C.ValueEditMessageReplyMarkupConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.InvoiceConfig{}).String():
-					m := n.C.ValueInvoiceConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.DeleteMessageConfig{}).String():
-					m := n.C.ValueDeleteMessageConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.PinChatMessageConfig{}).String():
-					m := n.C.ValuePinChatMessageConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.UnpinChatMessageConfig{}).String():
-					m := n.C.ValueUnpinChatMessageConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.SetChatTitleConfig{}).String():
-					m := n.C.ValueSetChatTitleConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.SetChatDescriptionConfig{}).String():
-					m := n.C.ValueSetChatDescriptionConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				case reflect.TypeOf(tgbotapi.DeleteChatPhotoConfig{}).String():
-					m := n.C.ValueDeleteChatPhotoConfig
-					message, err := bot.Send(m)
-
-					r = ResponseMessage{}
-					r.R6 = message
-					r.R2 = NewConcreteError(err)
-				//case reflect.TypeOf(tgbotapi.SetChatPhotoConfig{}).String():
-				//	m := n.C.ValueSetChatPhotoConfig
-				//	message, err := bot.Send(m)
-				//
-				//	r = ResponseMessage{}
-				//	r.R6 = message
-				//	r.R2 = NewConcreteError(err)
-				default:
-					r = ResponseMessage{}
-					r.R2 = NewConcreteError(fmt.Errorf("not implemented"))
+			j = &job{d: d, codec: JSONCodec, version: LegacyProtocolVersion}
+
+			body, err := decompress(d.Body, d.ContentEncoding)
+			if err != nil {
+				j.err = err
+			} else if codec, err := CodecFor(d.ContentType); err != nil {
+				j.err = err
+			} else {
+				j.codec = codec
+				j.n, j.version, j.err = DecodeRequest(codec, body)
+
+				// The legacy format is JSON only, other codecs reply even
+				// undecodable requests with the current one.
+				if codec != JSONCodec {
+					j.version = ProtocolVersion
+				}
+			}
+			if j.err == nil {
+				j.bot = s.botName(&j.n, &d)
+				if key := chatKey(&j.n); key != "" {
+					j.key = j.bot + "/" + key
 				}
+			}
+
+			if !chats.acquire(j) {
+				continue
+			}
+		}
+
+		select {
+		case jobs <- j:
+		case <-ctx.Done():
+			j.d.Nack(false, true)
+			return nil
+		}
+	}
+}
+
+// drain stops the consumer and requeues every delivery that was not started.
+func (s *Server) drain(ch *amqp.Channel, msgs <-chan amqp.Delivery, unstarted []*job) {
+	err := ch.Cancel(s.consumer, false)
+	if err != nil {
+		s.ErrorHandler(err)
+	}
+
+	for _, j := range unstarted {
+		j.d.Nack(false, true)
+	}
+
+	// msgs is only closed once the broker confirmed the cancellation,
+	// otherwise the remaining deliveries are requeued with the channel.
+	if err == nil {
+		for d := range msgs {
+			d.Nack(false, true)
+		}
+	}
+}
+
+func (s *Server) wait(wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	if s.ShutdownTimeout <= 0 {
+		<-done
+		return
+	}
+
+	select {
+	case <-done:
+	case <-time.After(s.ShutdownTimeout):
+		s.ErrorHandler(NewErrorRemoteBot(FailedShutdown, fmt.Errorf("requests still running after %s", s.ShutdownTimeout)))
+	}
+}
+
+// handle executes j, replies and acknowledges it, unless j must wait for the
+// rate limits or to retry a flood wait: it then returns when to handle j
+// again, otherwise the zero time.
+func (s *Server) handle(ch *amqp.Channel, j *job) time.Time {
+	d := j.d
+	if expired(&d) {
+		// A flood waited request was executed already.
+		if j.floodWait == nil {
+			atomic.AddUint64(&s.expired, 1)
+		}
+		d.Ack(false)
+		return time.Time{}
+	}
+
+	if j.err != nil {
+		err := NewErrorRemoteBot(FailedConvertBodyRequest, j.err)
+		s.ErrorHandler(err)
+		s.reject(ch, j, DeadLetterUndecodable, err)
+		return time.Time{}
+	}
+
+	var r ResponseMessage
+
+	bot, err := s.lookupBot(j.bot)
+	if err != nil {
+		r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = j.n.Operation, j.n.CorrelationId
+	} else {
+		var retryAt time.Time
+		r, retryAt, err = s.runOnce(bot, s.relay(j.bot, bot), j)
+		if err == errExpired {
+			atomic.AddUint64(&s.expired, 1)
+			d.Ack(false)
+			return time.Time{}
+		}
+
+		if err != nil {
+			s.ErrorHandler(err)
+
+			// The call may have reached Telegram before failing, running it
+			// again could repeat it.
+			s.reject(ch, j, DeadLetterFailed, err)
+			return time.Time{}
+		}
+
+		if !retryAt.IsZero() {
+			return retryAt
+		}
+	}
+
+	s.reply(ch, j, r)
+	d.Ack(false)
+	return time.Time{}
+}
+
+// errExpired is returned by runRetrying for a request the rate limits would
+// only let through after its deadline.
+var errExpired = errors.New("request expired before its rate limited slot")
+
+// Expired returns how many requests were dropped without being executed
+// because their client had stopped waiting for the reply, or would have by the
+// time the rate limits let them through.
+func (s *Server) Expired() uint64 {
+	return atomic.LoadUint64(&s.expired)
+}
+
+// expired reports whether the deadline of d passed. The broker drops expired
+// requests before delivering them, but not the ones already prefetched or
+// queued behind a chat. Server and client clocks are assumed to be in sync.
+func expired(d *amqp.Delivery) bool {
+	deadline := deadline(d)
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
+
+// deadline returns the deadline of d, the zero time if it has none.
+func deadline(d *amqp.Delivery) time.Time {
+	value, ok := d.Headers[HeaderDeadline].(string)
+	if !ok {
+		return time.Time{}
+	}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetUserProfilePhotos:
-				userProfilePhotos, err := bot.GetUserProfilePhotos(n.Config)
+	deadline, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}
+	}
 
-				r = ResponseMessage{}
-				r.R7 = userProfilePhotos
-				r.R2 = NewConcreteError(err)
+	return deadline
+}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetFile:
-				file, err := bot.GetFile(n.Config2)
+// runOnce is like runRetrying but returns the stored response of an
+// idempotent request already executed, and stores it otherwise.
+func (s *Server) runOnce(bot *tgbotapi.BotAPI, relay *updatesRelay, j *job) (ResponseMessage, time.Time, error) {
+	n := &j.n
+	if n.IdempotencyKey == "" || s.IdempotencyStore == nil {
+		return s.runRetrying(bot, relay, j)
+	}
 
-				r = ResponseMessage{}
-				r.R8 = file
-				r.R2 = NewConcreteError(err)
+	// Keys are per bot, clients of different bots may pick the same ones.
+	key := j.bot + "/" + n.IdempotencyKey
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetUpdates:
-				updates, err := bot.GetUpdates(n.Config3)
+	r, ok := s.IdempotencyStore.Get(key)
+	if ok {
+		r.CorrelationId = n.CorrelationId
+		return r, time.Time{}, nil
+	}
 
-				r = ResponseMessage{}
-				r.R9 = updates
-				r.R2 = NewConcreteError(err)
+	r, retryAt, err := s.runRetrying(bot, relay, j)
+	if err == nil && retryAt.IsZero() && r.R2.IsNil {
+		s.IdempotencyStore.Put(key, r, s.IdempotencyWindow)
+	}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationRemoveWebhook:
-				apiResponse, err := bot.RemoveWebhook()
+	return r, retryAt, err
+}
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+// runRetrying is like run but delays sending calls to their rate limited
+// slot, and retries the calls Telegram rejected for flood control as long as
+// it can wait before the deadline and MaxFloodWait. It returns when to run j
+// again to do so, and errExpired, without running j, if the rate limits would
+// delay j past the deadline.
+func (s *Server) runRetrying(bot *tgbotapi.BotAPI, relay *updatesRelay, j *job) (ResponseMessage, time.Time, error) {
+	deadline := deadline(&j.d)
+
+	if chat, sending := sendingChat(&j.n); sending && !j.reserved {
+		at, ok := s.limiter(j.bot).reserve(chat, deadline)
+		if !ok {
+			// Telegram was called already, the flood wait is the reply.
+			if j.floodWait != nil {
+				return *j.floodWait, time.Time{}, nil
+			}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationSetWebhook:
-				apiResponse, err := bot.SetWebhook(n.Config4)
+			return ResponseMessage{}, time.Time{}, errExpired
+		}
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		if at.After(time.Now()) {
+			j.reserved = true
+			return ResponseMessage{}, at, nil
+		}
+	}
+	j.reserved = false
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetWebhookInfo:
-				webhookInfo, err := bot.GetWebhookInfo()
+	r, err := s.run(bot, relay, &j.n)
+	if err != nil || r.R2.RetryAfter == 0 {
+		return r, time.Time{}, err
+	}
 
-				r = ResponseMessage{}
-				r.R10 = webhookInfo
-				r.R2 = NewConcreteError(err)
+	wait := s.MaxFloodWait
+	if !deadline.IsZero() {
+		if left := time.Until(deadline); left < wait {
+			wait = left
+		}
+	}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetUpdatesChan:
-				r = ResponseMessage{}
-				r.R2 = NewConcreteError(fmt.Errorf("not implemented"))
+	retryAfter := time.Duration(r.R2.RetryAfter) * time.Second
+	if retryAfter > wait {
+		return r, time.Time{}, nil
+	}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationListenForWebhook:
-				r = ResponseMessage{}
-				r.R2 = NewConcreteError(fmt.Errorf("not implemented"))
+	j.floodWait = &r
+	return r, time.Now().Add(retryAfter), nil
+}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationAnswerInlineQuery:
-				apiResponse, err := bot.AnswerInlineQuery(n.Config5)
+// run executes n, turning a panic into an error.
+func (s *Server) run(bot *tgbotapi.BotAPI, relay *updatesRelay, n *RequestMessage) (r ResponseMessage, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%s %s panicked: %v", n.Operation, n.CorrelationId, p)
+		}
+	}()
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+	return s.execute(bot, relay, n), nil
+}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationAnswerCallbackQuery:
-				apiResponse, err := bot.AnswerCallbackQuery(n.Config6)
+// reject dead-letters d, or drops it without a DeadLetterExchange, and
+// replies the error so the client does not wait for its timeout.
+func (s *Server) reject(ch *amqp.Channel, j *job, reason string, cause error) {
+	d := j.d
+	if s.DeadLetterExchange != "" {
+		err := PublishDeadLetter(ch, s.DeadLetterExchange, d, reason, cause)
+		if err != nil {
+			s.ErrorHandler(err)
+			d.Nack(false, true)
+			return
+		}
+	}
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+	var r ResponseMessage
+	r.R2 = NewConcreteError(cause)
+	r.CorrelationId = d.CorrelationId
+	s.reply(ch, j, r)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationKickChatMember:
-				apiResponse, err := bot.KickChatMember(n.Config7)
+	d.Nack(false, false)
+}
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+// reply publishes r to the reply queue of the request of j, with its codec
+// and in its format.
+func (s *Server) reply(ch *amqp.Channel, j *job, r ResponseMessage) {
+	d := j.d
+	if d.ReplyTo == "" {
+		return
+	}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationLeaveChat:
-				apiResponse, err := bot.LeaveChat(n.Config8)
+	response, err := EncodeResponse(j.codec, &r, j.version)
+	if err != nil {
+		s.ErrorHandler(err)
+		return
+	}
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+	// Older clients do not advertise the encodings they decode.
+	encoding := ""
+	if acceptsGzip(&d) {
+		response, encoding = compress(response, s.CompressionThreshold)
+	}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetChat:
-				chat, err := bot.GetChat(n.Config8)
+	err = ch.Publish(
+		"",        // exchange
+		d.ReplyTo, // routing key
+		false,     // mandatory
+		false,     // immediate
+		amqp.Publishing{
+			ContentType:     j.codec.ContentType(),
+			ContentEncoding: encoding,
+			CorrelationId:   d.CorrelationId,
+			Body:            response,
+		})
+	if err != nil {
+		s.ErrorHandler(NewErrorRemoteBot(FailedMessagePublish, err))
+	}
+}
 
-				r = ResponseMessage{}
-				r.R12 = chat
-				r.R2 = NewConcreteError(err)
+func (s *Server) execute(bot *tgbotapi.BotAPI, relay *updatesRelay, n *RequestMessage) ResponseMessage {
+	var r ResponseMessage
+	switch n.Operation {
+	case OperationMakeRequest:
+		apiResponse, err := bot.MakeRequest(n.Endpoint, n.Params)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationUploadFile:
+		apiResponse, err := bot.UploadFile(n.Endpoint, n.Params2, n.Fieldname, n.File)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetFileDirectURL:
+		fileID, err := bot.GetFileDirectURL(n.FileID)
+
+		r = ResponseMessage{}
+		r.R3 = fileID
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetMe:
+		user, err := bot.GetMe()
+
+		r = ResponseMessage{}
+		r.R4 = user
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationIsMessageToMe:
+		yes := bot.IsMessageToMe(n.Message)
+
+		r = ResponseMessage{}
+		r.R5 = yes
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationSend:
+		switch n.C.Type {
+		case reflect.TypeOf(tgbotapi.MessageConfig{}).String():
+			m := n.C.ValueMessageConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.ForwardConfig{}).String():
+			m := n.C.ValueForwardConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.PhotoConfig{}).String():
+			m := n.C.ValuePhotoConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.AudioConfig{}).String():
+			m := n.C.ValueAudioConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.DocumentConfig{}).String():
+			m := n.C.ValueDocumentConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.StickerConfig{}).String():
+			m := n.C.ValueStickerConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.VideoConfig{}).String():
+			m := n.C.ValueVideoConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.AnimationConfig{}).String():
+			m := n.C.ValueAnimationConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.VideoNoteConfig{}).String():
+			m := n.C.ValueVideoNoteConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.VoiceConfig{}).String():
+			m := n.C.ValueVoiceConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.MediaGroupConfig{}).String():
+			m := n.C.ValueMediaGroupConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.LocationConfig{}).String():
+			m := n.C.ValueLocationConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.VenueConfig{}).String():
+			m := n.C.ValueVenueConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.ContactConfig{}).String():
+			m := n.C.ValueContactConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.GameConfig{}).String():
+			m := n.C.ValueGameConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.SetGameScoreConfig{}).String():
+			m := n.C.ValueSetGameScoreConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.GetGameHighScoresConfig{}).String():
+			m := n.C.ValueGetGameHighScoresConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.ChatActionConfig{}).String():
+			m := n.C.ValueChatActionConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.EditMessageTextConfig{}).String():
+			m := n.C.ValueEditMessageTextConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.EditMessageCaptionConfig{}).String():
+			m := n.C.ValueEditMessageCaptionConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.EditMessageReplyMarkupConfig{}).String():
+			m := n.C.ValueEditMessageReplyMarkupConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.InvoiceConfig{}).String():
+			m := n.C.ValueInvoiceConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.DeleteMessageConfig{}).String():
+			m := n.C.ValueDeleteMessageConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.PinChatMessageConfig{}).String():
+			m := n.C.ValuePinChatMessageConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.UnpinChatMessageConfig{}).String():
+			m := n.C.ValueUnpinChatMessageConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.SetChatTitleConfig{}).String():
+			m := n.C.ValueSetChatTitleConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.SetChatDescriptionConfig{}).String():
+			m := n.C.ValueSetChatDescriptionConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		case reflect.TypeOf(tgbotapi.DeleteChatPhotoConfig{}).String():
+			m := n.C.ValueDeleteChatPhotoConfig
+			message, err := bot.Send(m)
+
+			r = ResponseMessage{}
+			r.R6 = message
+			r.R2 = NewConcreteError(err)
+		//case reflect.TypeOf(tgbotapi.SetChatPhotoConfig{}).String():
+		//	m := n.C.ValueSetChatPhotoConfig
+		//	message, err := bot.Send(m)
+		//
+		//	r = ResponseMessage{}
+		//	r.R6 = message
+		//	r.R2 = NewConcreteError(err)
+		default:
+			r = ResponseMessage{}
+			r.R2 = NewConcreteError(fmt.Errorf("not implemented"))
+		}
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetChatAdministrators:
-				chatMembers, err := bot.GetChatAdministrators(n.Config8)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetUserProfilePhotos:
+		userProfilePhotos, err := bot.GetUserProfilePhotos(n.Config)
 
-				r = ResponseMessage{}
-				r.R13 = chatMembers
-				r.R2 = NewConcreteError(err)
+		r = ResponseMessage{}
+		r.R7 = userProfilePhotos
+		r.R2 = NewConcreteError(err)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetChatMembersCount:
-				count, err := bot.GetChatMembersCount(n.Config8)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetFile:
+		file, err := bot.GetFile(n.Config2)
 
-				r = ResponseMessage{}
-				r.R14 = count
-				r.R2 = NewConcreteError(err)
+		r = ResponseMessage{}
+		r.R8 = file
+		r.R2 = NewConcreteError(err)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetChatMember:
-				chatMember, err := bot.GetChatMember(n.Config9)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetUpdates:
+		updates, err := bot.GetUpdates(n.Config3)
 
-				r = ResponseMessage{}
-				r.R15 = chatMember
-				r.R2 = NewConcreteError(err)
+		r = ResponseMessage{}
+		r.R9 = updates
+		r.R2 = NewConcreteError(err)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationUnbanChatMember:
-				apiResponse, err := bot.UnbanChatMember(n.Config10)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationRemoveWebhook:
+		apiResponse, err := bot.RemoveWebhook()
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationRestrictChatMember:
-				apiResponse, err := bot.RestrictChatMember(n.Config11)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationSetWebhook:
+		apiResponse, err := bot.SetWebhook(n.Config4)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
-
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationPromoteChatMember:
-				apiResponse, err := bot.PromoteChatMember(n.Config12)
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetWebhookInfo:
+		webhookInfo, err := bot.GetWebhookInfo()
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetGameHighScores:
-				gameHighScores, err := bot.GetGameHighScores(n.Config13)
+		r = ResponseMessage{}
+		r.R10 = webhookInfo
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R16 = gameHighScores
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetUpdatesChan:
+		err := relay.StartPolling(n.Config3)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationAnswerShippingQuery:
-				apiResponse, err := bot.AnswerShippingQuery(n.Config14)
+		r = ResponseMessage{}
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationListenForWebhook:
+		err := relay.ListenForWebhook(n.Pattern)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationAnswerPreCheckoutQuery:
-				apiResponse, err := bot.AnswerPreCheckoutQuery(n.Config15)
+		r = ResponseMessage{}
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationAnswerInlineQuery:
+		apiResponse, err := bot.AnswerInlineQuery(n.Config5)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationDeleteMessage:
-				apiResponse, err := bot.DeleteMessage(n.Config16)
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationAnswerCallbackQuery:
+		apiResponse, err := bot.AnswerCallbackQuery(n.Config6)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationGetInviteLink:
-				link, err := bot.GetInviteLink(n.Config8)
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R3 = link
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationKickChatMember:
+		apiResponse, err := bot.KickChatMember(n.Config7)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationPinChatMessage:
-				apiResponse, err := bot.PinChatMessage(n.Config17)
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationLeaveChat:
+		apiResponse, err := bot.LeaveChat(n.Config8)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationUnpinChatMessage:
-				apiResponse, err := bot.UnpinChatMessage(n.Config18)
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetChat:
+		chat, err := bot.GetChat(n.Config8)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationSetChatTitle:
-				apiResponse, err := bot.SetChatTitle(n.Config19)
+		r = ResponseMessage{}
+		r.R12 = chat
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetChatAdministrators:
+		chatMembers, err := bot.GetChatAdministrators(n.Config8)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationSetChatDescription:
-				apiResponse, err := bot.SetChatDescription(n.Config20)
+		r = ResponseMessage{}
+		r.R13 = chatMembers
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetChatMembersCount:
+		count, err := bot.GetChatMembersCount(n.Config8)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationSetChatPhoto:
-				apiResponse, err := bot.SetChatPhoto(n.Config21)
+		r = ResponseMessage{}
+		r.R14 = count
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetChatMember:
+		chatMember, err := bot.GetChatMember(n.Config9)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			case OperationDeleteChatPhoto:
-				apiResponse, err := bot.DeleteChatPhoto(n.Config22)
+		r = ResponseMessage{}
+		r.R15 = chatMember
+		r.R2 = NewConcreteError(err)
 
-				r = ResponseMessage{}
-				r.R = apiResponse
-				r.R2 = NewConcreteError(err)
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationUnbanChatMember:
+		apiResponse, err := bot.UnbanChatMember(n.Config10)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			default:
-				r = ResponseMessage{}
-				r.R2 = NewConcreteError(fmt.Errorf("not implemented"))
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
 
-				r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
-			}
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationRestrictChatMember:
+		apiResponse, err := bot.RestrictChatMember(n.Config11)
 
-			response, err := json.Marshal(r)
-			if err != nil {
-				errorHandler(err)
-			}
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
 
-			err = ch.Publish(
-				"",        // exchange
-				d.ReplyTo, // routing key
-				false,     // mandatory
-				false,     // immediate
-				amqp.Publishing{
-					ContentType:   "text/plain",
-					CorrelationId: d.CorrelationId,
-					Body:          response,
-				})
-			if err != nil {
-				errorHandler(NewErrorRemoteBot(FailedMessagePublish, err))
-			}
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationPromoteChatMember:
+		apiResponse, err := bot.PromoteChatMember(n.Config12)
 
-			d.Ack(false)
-		}
-	}()
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetGameHighScores:
+		gameHighScores, err := bot.GetGameHighScores(n.Config13)
+
+		r = ResponseMessage{}
+		r.R16 = gameHighScores
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationAnswerShippingQuery:
+		apiResponse, err := bot.AnswerShippingQuery(n.Config14)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationAnswerPreCheckoutQuery:
+		apiResponse, err := bot.AnswerPreCheckoutQuery(n.Config15)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationDeleteMessage:
+		apiResponse, err := bot.DeleteMessage(n.Config16)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetInviteLink:
+		link, err := bot.GetInviteLink(n.Config8)
+
+		r = ResponseMessage{}
+		r.R3 = link
+		r.R2 = NewConcreteError(err)
 
-	<-forever
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationPinChatMessage:
+		apiResponse, err := bot.PinChatMessage(n.Config17)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationUnpinChatMessage:
+		apiResponse, err := bot.UnpinChatMessage(n.Config18)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationSetChatTitle:
+		apiResponse, err := bot.SetChatTitle(n.Config19)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationSetChatDescription:
+		apiResponse, err := bot.SetChatDescription(n.Config20)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationSetChatPhoto:
+		apiResponse, err := bot.SetChatPhoto(n.Config21)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationDeleteChatPhoto:
+		apiResponse, err := bot.DeleteChatPhoto(n.Config22)
+
+		r = ResponseMessage{}
+		r.R = apiResponse
+		r.R2 = NewConcreteError(err)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	case OperationGetCapabilities:
+		r = ResponseMessage{}
+		r.R17 = s.capabilities()
+		r.R2 = NewConcreteError(nil)
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	default:
+		r = ResponseMessage{}
+		r.R2 = NewConcreteError(fmt.Errorf("not implemented"))
+
+		r.Operation, r.CorrelationId = n.Operation, n.CorrelationId
+	}
 
-	return nil
+	return r
 }
@@ -0,0 +1,488 @@
+package rbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/streadway/amqp"
+)
+
+const (
+	UpdatesExchange     = "tgbotapi.updates"
+	UpdatesExchangeKind = "fanout"
+	WebhookExchange     = "tgbotapi.webhook"
+	WebhookExchangeKind = "direct"
+	UpdatesRetryDelay   = 3 * time.Second
+	DefaultBuffer       = 100
+)
+
+// BotExchangeName returns the name of the updates or webhook exchange of the
+// bot called name on a Server hosting several bots.
+func BotExchangeName(exchange string, name string) string {
+	if name == "" {
+		return exchange
+	}
+
+	return exchange + "." + name
+}
+
+// updatesRelay runs on the server and publishes every update received from
+// Telegram to the updates exchange, so any number of RemoteBotAPI clients
+// can consume them from their own queues.
+type updatesRelay struct {
+	updatesExchange string
+	webhookExchange string
+
+	name         string
+	connection   *amqp.Connection
+	bot          *tgbotapi.BotAPI
+	webhooks     *webhooks
+	codec        Codec
+	errorHandler func(error)
+
+	mutex    sync.Mutex
+	ch       *amqp.Channel
+	confirms <-chan amqp.Confirmation
+	returns  <-chan amqp.Return
+	polling  bool
+	done     chan struct{}
+}
+
+func newUpdatesRelay(connection *amqp.Connection, bot *tgbotapi.BotAPI, errorHandler func(error)) *updatesRelay {
+	return &updatesRelay{
+		updatesExchange: UpdatesExchange,
+		webhookExchange: WebhookExchange,
+		connection:      connection,
+		bot:             bot,
+		webhooks:        newWebhooks(http.DefaultServeMux),
+		codec:           JSONCodec,
+		errorHandler:    errorHandler,
+		done:            make(chan struct{}),
+	}
+}
+
+// errUnroutable is returned by publish for an update no queue is bound to
+// receive, while no client consumes them.
+var errUnroutable = errors.New("no queue bound for the update")
+
+// channel returns the channel used to publish updates, opening it in confirm
+// mode and declaring the updates and webhook exchanges on first use.
+func (r *updatesRelay) channel() (*amqp.Channel, error) {
+	if r.ch != nil {
+		return r.ch, nil
+	}
+
+	ch, err := r.connection.Channel()
+	if err != nil {
+		return nil, NewErrorRemoteBot(FailedOpenChannel, err)
+	}
+
+	err = DeclareUpdatesExchange(ch, r.updatesExchange, UpdatesExchangeKind)
+	if err != nil {
+		ch.Close()
+		return nil, NewErrorRemoteBot(FailedDeclareExchange, err)
+	}
+
+	err = DeclareUpdatesExchange(ch, r.webhookExchange, WebhookExchangeKind)
+	if err != nil {
+		ch.Close()
+		return nil, NewErrorRemoteBot(FailedDeclareExchange, err)
+	}
+
+	err = ch.Confirm(false)
+	if err != nil {
+		ch.Close()
+		return nil, NewErrorRemoteBot(FailedOptionConfirm, err)
+	}
+
+	// Updates are published one at a time, a single confirm or return is
+	// ever pending.
+	r.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	r.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	r.ch = ch
+	return ch, nil
+}
+
+// StartPolling starts the long-polling loop. Only one loop runs per relay,
+// later calls are no-ops regardless of the config they carry.
+func (r *updatesRelay) StartPolling(config tgbotapi.UpdateConfig) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.polling {
+		return nil
+	}
+
+	if _, err := r.channel(); err != nil {
+		return err
+	}
+
+	r.polling = true
+	go r.poll(config)
+
+	return nil
+}
+
+func (r *updatesRelay) poll(config tgbotapi.UpdateConfig) {
+	for {
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+
+		updates, err := r.bot.GetUpdates(config)
+		if err != nil {
+			r.errorHandler(err)
+			time.Sleep(UpdatesRetryDelay)
+
+			continue
+		}
+
+		for _, update := range updates {
+			if update.UpdateID < config.Offset {
+				continue
+			}
+
+			// The offset only moves past updates that reached a client queue,
+			// so a failed publish fetches the same updates again. Telegram
+			// keeps them meanwhile, while no client consumes them too.
+			err = r.publish(r.updatesExchange, "", update)
+			if err != nil {
+				if err != errUnroutable {
+					r.errorHandler(err)
+				}
+				time.Sleep(UpdatesRetryDelay)
+
+				break
+			}
+
+			config.Offset = update.UpdateID + 1
+		}
+	}
+}
+
+// ListenForWebhook registers a http handler for pattern that publishes the
+// received updates to the webhook exchange, using pattern as routing key.
+// The handler is registered on the mux of the relay, Server.WebhookMux.
+func (r *updatesRelay) ListenForWebhook(pattern string) error {
+	r.mutex.Lock()
+	_, err := r.channel()
+	r.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return r.webhooks.register(pattern, r.name, r)
+}
+
+// serveWebhook publishes the update received by the handler of pattern.
+func (r *updatesRelay) serveWebhook(pattern string, w http.ResponseWriter, req *http.Request) {
+	bytes, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var update tgbotapi.Update
+	err = json.Unmarshal(bytes, &update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Telegram retries the update when it does not get a 2XX response.
+	err = r.publish(r.webhookExchange, pattern, update)
+	if err != nil {
+		if err != errUnroutable {
+			r.errorHandler(err)
+		}
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+}
+
+// webhooks registers the webhook handlers of the relays of a Server on its
+// mux, which cannot unregister them. A pattern belongs to the bot that first
+// listened on it, its handler publishes through the current relay of that
+// bot.
+type webhooks struct {
+	mux *http.ServeMux
+
+	mutex  sync.Mutex
+	owners map[string]string
+	relays map[string]*updatesRelay
+}
+
+func newWebhooks(mux *http.ServeMux) *webhooks {
+	return &webhooks{
+		mux:    mux,
+		owners: make(map[string]string),
+		relays: make(map[string]*updatesRelay),
+	}
+}
+
+// register makes the handler of pattern publish through relay, the one of
+// the bot called name, registering it on first use.
+func (w *webhooks) register(pattern string, name string, relay *updatesRelay) (err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	owner, ok := w.owners[pattern]
+	if ok && owner != name {
+		return fmt.Errorf("webhook pattern %q is already used by another bot", pattern)
+	}
+
+	if !ok {
+		// The mux panics on patterns registered by others.
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("can't register webhook pattern %q: %v", pattern, p)
+			}
+		}()
+
+		w.mux.HandleFunc(pattern, func(rw http.ResponseWriter, req *http.Request) {
+			w.serve(pattern, rw, req)
+		})
+		w.owners[pattern] = name
+	}
+
+	w.relays[pattern] = relay
+
+	return nil
+}
+
+func (w *webhooks) serve(pattern string, rw http.ResponseWriter, req *http.Request) {
+	w.mutex.Lock()
+	relay := w.relays[pattern]
+	w.mutex.Unlock()
+
+	if relay == nil {
+		http.Error(rw, "webhook not relayed", http.StatusServiceUnavailable)
+		return
+	}
+
+	relay.serveWebhook(pattern, rw, req)
+}
+
+// detach makes the handlers fail until their bots listen on them again, once
+// the relays are closed.
+func (w *webhooks) detach() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.relays = make(map[string]*updatesRelay)
+}
+
+// publish publishes update and waits for the broker to confirm it, returning
+// errUnroutable if it was returned instead of reaching a queue.
+func (r *updatesRelay) publish(exchange string, key string, update tgbotapi.Update) error {
+	body, err := r.codec.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ch, err := r.channel()
+	if err != nil {
+		return err
+	}
+
+	err = ch.Publish(
+		exchange, // exchange
+		key,      // routing key
+		true,     // mandatory
+		false,    // immediate
+		amqp.Publishing{
+			ContentType: r.codec.ContentType(),
+			Body:        body,
+		})
+	if err != nil {
+		// The channel is unusable after an error, open a new one next time.
+		ch.Close()
+		r.ch = nil
+		return NewErrorRemoteBot(FailedMessagePublish, err)
+	}
+
+	c, ok := <-r.confirms
+	if !ok {
+		r.ch = nil
+		return NewErrorRemoteBot(FailedConnectionLost, nil)
+	}
+
+	// The broker returns an unroutable update before confirming it.
+	select {
+	case <-r.returns:
+		return errUnroutable
+	default:
+	}
+
+	if !c.Ack {
+		return NewErrorRemoteBot(FailedMessageConfirm, fmt.Errorf("nacked by the broker"))
+	}
+
+	return nil
+}
+
+// setConnection makes the relay publish over connection, after the server
+// reconnected.
+func (r *updatesRelay) setConnection(connection *amqp.Connection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.ch != nil {
+		r.ch.Close()
+		r.ch = nil
+	}
+	r.connection = connection
+}
+
+func (r *updatesRelay) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+
+	if r.ch != nil {
+		r.ch.Close()
+		r.ch = nil
+	}
+}
+
+// updatesSubscription describes the queue a client binds to receive updates
+// and the request that makes the server feed it.
+type updatesSubscription struct {
+	exchange       string
+	kind           string
+	key            string
+	requestMessage RequestMessage
+}
+
+// subscribe binds a new updates queue and then asks the server to publish
+// updates, so no update published in between is lost.
+func (rbot *RemoteBotAPI) subscribe(ctx context.Context, subscription *updatesSubscription) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	s, remoteBotErr := rbot.currentSession(ctx)
+	if remoteBotErr != nil {
+		return nil, nil, remoteBotErr
+	}
+
+	ch, err := s.connection.Channel()
+	if err != nil {
+		return nil, nil, NewErrorRemoteBot(FailedOpenChannel, err)
+	}
+
+	q, remoteBotErr := CreateUpdatesQueue(ch, subscription.exchange, subscription.kind, subscription.key)
+	if remoteBotErr != nil {
+		ch.Close()
+		return nil, nil, remoteBotErr
+	}
+
+	msgs, err := CreateConsumeChannel(ch, q.Name)
+	if err != nil {
+		ch.Close()
+		return nil, nil, NewErrorRemoteBot(FailedMessageConsume, err)
+	}
+
+	requestMessage := subscription.requestMessage
+	requestMessage.CorrelationId = randomString(RandomStringLength)
+
+	response, remoteBotErr := rbot.rpcWithContext(ctx, &requestMessage)
+	if remoteBotErr != nil {
+		ch.Close()
+		return nil, nil, remoteBotErr
+	}
+
+	err = response.R2.ToError()
+	if err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+
+	return ch, msgs, nil
+}
+
+// forwardUpdates decodes the deliveries of an updates queue into updates,
+// subscribing again whenever the connection is lost. It closes updates once
+// StopReceivingUpdates or RemoteBotClose is called.
+func (rbot *RemoteBotAPI) forwardUpdates(subscription *updatesSubscription, ch *amqp.Channel, msgs <-chan amqp.Delivery, updates chan<- tgbotapi.Update) {
+	defer close(updates)
+
+	for {
+		if !rbot.consumeUpdates(ch, msgs, updates) {
+			return
+		}
+
+		for {
+			var err error
+
+			ctx, cancel := context.WithTimeout(context.Background(), rbot.Timeout)
+			ch, msgs, err = rbot.subscribe(ctx, subscription)
+			cancel()
+
+			if err == nil {
+				break
+			}
+
+			select {
+			case <-rbot.shutdownChannel:
+				return
+			case <-rbot.closed:
+				return
+			case <-time.After(UpdatesRetryDelay):
+			}
+		}
+	}
+}
+
+// consumeUpdates forwards updates until msgs is closed, in which case it
+// returns true, or until the client stops receiving updates.
+func (rbot *RemoteBotAPI) consumeUpdates(ch *amqp.Channel, msgs <-chan amqp.Delivery, updates chan<- tgbotapi.Update) bool {
+	defer ch.Close()
+
+	for {
+		select {
+		case <-rbot.shutdownChannel:
+			return false
+		case <-rbot.closed:
+			return false
+		case d, ok := <-msgs:
+			if !ok {
+				return true
+			}
+
+			codec, err := CodecFor(d.ContentType)
+			if err != nil {
+				continue
+			}
+
+			var update tgbotapi.Update
+			err = codec.Unmarshal(d.Body, &update)
+			if err != nil {
+				continue
+			}
+
+			select {
+			case updates <- update:
+			case <-rbot.shutdownChannel:
+				return false
+			case <-rbot.closed:
+				return false
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package rbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhooksRegister(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/taken", func(http.ResponseWriter, *http.Request) {})
+
+	w := newWebhooks(mux)
+	relay := &updatesRelay{}
+
+	if err := w.register("/hook", "bot", relay); err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+
+	// A relay of the same bot, after the server ran again, takes it over.
+	if err := w.register("/hook", "bot", &updatesRelay{}); err != nil {
+		t.Errorf("register() again error = %v", err)
+	}
+
+	if err := w.register("/hook", "other", relay); err == nil {
+		t.Error("register() for another bot error = nil, want an error")
+	}
+
+	if err := w.register("/taken", "bot", relay); err == nil {
+		t.Error("register() of a pattern of the mux error = nil, want an error")
+	}
+}
+
+func TestWebhooksDetach(t *testing.T) {
+	mux := http.NewServeMux()
+	w := newWebhooks(mux)
+
+	if err := w.register("/hook", "bot", &updatesRelay{}); err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+	w.detach()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hook", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}